@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Protocol ID for answer-generation streams
+const answerProtocolID = "/p2p-rag/answer/0.0.1"
+
+// Generation is much slower than retrieval, so the client API gets more time
+const answerTimeout = 2 * time.Minute
+
+// How long a peer may take to send its request, and to accept each message
+// of the answer
+const (
+	answerReadTimeout  = 10 * time.Second
+	answerWriteTimeout = 10 * time.Second
+)
+
+// AnswerRequest asks a peer to answer a question with its own LLM and documents
+type AnswerRequest struct {
+	QueryId      string   `json:"queryId"`
+	Question     string   `json:"question"`
+	Context      []string `json:"context,omitempty"`
+	ExpertiseKey string   `json:"expertise_key,omitempty"`
+	Stream       bool     `json:"stream"`
+}
+
+// AnswerCitation references a document the answer was generated from
+type AnswerCitation struct {
+	Title    string                 `json:"title"`
+	Source   string                 `json:"source"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// AnswerResponse is one message of an answer stream. Streaming answers send
+// any number of token messages followed by a final message with Done set;
+// an error message ends the stream early.
+type AnswerResponse struct {
+	Success   bool             `json:"success"`
+	Error     string           `json:"error,omitempty"`
+	Token     string           `json:"token,omitempty"`
+	Done      bool             `json:"done,omitempty"`
+	Answer    string           `json:"answer,omitempty"`
+	Citations []AnswerCitation `json:"citations,omitempty"`
+}
+
+// setupAnswerProtocol initializes the answer protocol handler
+func setupAnswerProtocol(host host.Host) {
+	host.SetStreamHandler(protocol.ID(answerProtocolID), handleAnswerStream)
+}
+
+// handleAnswerStream handles incoming answer streams from other peers
+func handleAnswerStream(stream network.Stream) {
+	defer stream.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	encoder := json.NewEncoder(rw.Writer)
+
+	send := func(response AnswerResponse) error {
+		stream.SetWriteDeadline(time.Now().Add(answerWriteTimeout))
+		if err := encoder.Encode(response); err != nil {
+			return err
+		}
+		return rw.Writer.Flush()
+	}
+
+	var request AnswerRequest
+	stream.SetReadDeadline(time.Now().Add(answerReadTimeout))
+	if err := json.NewDecoder(rw.Reader).Decode(&request); err != nil {
+		logger.Warn("❌ Error decoding answer request:", err)
+		send(AnswerResponse{Success: false, Error: "Failed to decode request"})
+		return
+	}
+	stream.SetReadDeadline(time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), answerTimeout)
+	defer cancel()
+	cancelOnReset(stream, cancel)
+
+	logger.Info("📥 Received answer request from peer:", stream.Conn().RemotePeer())
+
+	if request.Question == "" {
+		send(AnswerResponse{Success: false, Error: "Question must not be empty"})
+		return
+	}
+
+	if err := forwardAnswerToLocalAPI(ctx, request, send); err != nil {
+		logger.Warn("❌ Error forwarding answer request to local API:", err)
+		send(AnswerResponse{Success: false, Error: fmt.Sprintf("Failed to generate answer: %s", err.Error())})
+		return
+	}
+
+	logger.Info("📤 Sent answer to peer:", stream.Conn().RemotePeer())
+}
+
+// cancelOnReset calls cancel once the requester resets the stream, which is
+// how it gives up. Requesters send nothing after their request, and one that
+// closes its write side only causes io.EOF, which leaves the work running.
+func cancelOnReset(stream network.Stream, cancel context.CancelFunc) {
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := stream.Read(buf); err != nil {
+				if !errors.Is(err, io.EOF) {
+					cancel()
+				}
+				return
+			}
+		}
+	}()
+}
+
+// forwardAnswerToLocalAPI asks the local client API to generate an answer.
+// Streamed tokens and the final answer are passed to emit as they arrive.
+// Cancelling ctx aborts the request to the client API.
+func forwardAnswerToLocalAPI(ctx context.Context, request AnswerRequest, emit func(AnswerResponse) error) error {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal answer request: %w", err)
+	}
+
+	client := &http.Client{Timeout: answerTimeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, clientApiUrl+"/answer", bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create answer request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhookRequest(req, jsonData)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send question to answer API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("answer API returned error status: %d", resp.StatusCode)
+	}
+
+	// Non-streaming answers come back as a single JSON document, streaming
+	// answers as newline-delimited JSON with a final "done" line
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Token     string           `json:"token"`
+			Done      bool             `json:"done"`
+			Answer    string           `json:"answer"`
+			Citations []AnswerCitation `json:"citations"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			return fmt.Errorf("failed to parse answer response: %w", err)
+		}
+
+		if request.Stream && !message.Done {
+			if err := emit(AnswerResponse{Success: true, Token: message.Token}); err != nil {
+				return fmt.Errorf("failed to relay token: %w", err)
+			}
+			continue
+		}
+
+		return emit(AnswerResponse{
+			Success:   true,
+			Done:      true,
+			Answer:    message.Answer,
+			Citations: message.Citations,
+		})
+	}
+}
+
+// askRemotePeer sends a question to a remote peer. Every message received is
+// passed to emit until the answer is complete or the peer reports an error.
+func askRemotePeer(ctx context.Context, host host.Host, peerIdStr string, request AnswerRequest, emit func(AnswerResponse) error) error {
+	peerID, err := peer.Decode(peerIdStr)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	if host.Network().Connectedness(peerID) != network.Connected {
+		return fmt.Errorf("not connected to peer %s", peerIdStr)
+	}
+
+	stream, err := host.NewStream(ctx, peerID, protocol.ID(answerProtocolID))
+	if err != nil {
+		return fmt.Errorf("failed to open stream to peer: %w", err)
+	}
+	defer stream.Close()
+
+	// Reading the answer does not watch ctx, so reset the stream once the
+	// caller is gone, which also tells the peer to stop generating
+	stop := context.AfterFunc(ctx, func() { stream.Reset() })
+	defer stop()
+	stream.SetDeadline(time.Now().Add(answerTimeout))
+
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+
+	logger.Info("📤 Sending answer request to peer:", peerID)
+
+	if err := json.NewEncoder(rw.Writer).Encode(request); err != nil {
+		return fmt.Errorf("failed to encode answer request: %w", err)
+	}
+
+	if err := rw.Writer.Flush(); err != nil {
+		return fmt.Errorf("failed to send answer request: %w", err)
+	}
+
+	decoder := json.NewDecoder(rw.Reader)
+	for {
+		var response AnswerResponse
+		if err := decoder.Decode(&response); err != nil {
+			return fmt.Errorf("failed to decode answer response: %w", err)
+		}
+
+		if !response.Success {
+			return fmt.Errorf("answer failed on peer: %s", response.Error)
+		}
+
+		if err := emit(response); err != nil {
+			return err
+		}
+
+		if response.Done {
+			logger.Info("📥 Received answer from peer:", peerID)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useClientApi points the node at handler as its client API for the rest of the test
+func useClientApi(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	previous := clientApiUrl
+	clientApiUrl = server.URL
+	t.Cleanup(func() { clientApiUrl = previous })
+}
+
+func TestAskRemotePeerStreamsTokens(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupAnswerProtocol(local)
+	useClientApi(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"token":"Hello"}`)
+		fmt.Fprintln(w, `{"token":" world"}`)
+		fmt.Fprintln(w, `{"done":true,"answer":"Hello world"}`)
+	})
+
+	var received []AnswerResponse
+	err := askRemotePeer(context.Background(), remote, local.ID().String(), AnswerRequest{Question: "Hi?", Stream: true}, func(response AnswerResponse) error {
+		received = append(received, response)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 3 || received[0].Token != "Hello" || !received[2].Done || received[2].Answer != "Hello world" {
+		t.Errorf("unexpected answer messages %+v", received)
+	}
+}
+
+func TestAnswerStreamSurvivesCloseWrite(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupAnswerProtocol(local)
+	useClientApi(t, func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		// Give a premature cancellation time to reach the client API request
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+		fmt.Fprintln(w, `{"done":true,"answer":"Hello"}`)
+	})
+
+	stream, err := remote.NewStream(context.Background(), local.ID(), answerProtocolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if err := json.NewEncoder(stream).Encode(AnswerRequest{Question: "Hi?"}); err != nil {
+		t.Fatal(err)
+	}
+	// Done writing, but still waiting for the answer
+	if err := stream.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	var response AnswerResponse
+	if err := json.NewDecoder(stream).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Success || response.Answer != "Hello" {
+		t.Errorf("response = %+v, want the answer", response)
+	}
+}
+
+func TestAnswerStreamCancelsClientApiRequest(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupAnswerProtocol(local)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	useClientApi(t, func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a closed connection once the body was read
+		io.ReadAll(r.Body)
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- askRemotePeer(ctx, remote, local.ID().String(), AnswerRequest{Question: "Hi?"}, func(AnswerResponse) error { return nil })
+	}()
+
+	<-started
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("cancelled question succeeded")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("client API request kept running after the requester gave up")
+	}
+}
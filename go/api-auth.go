@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Prefix of -api-listen values that are Unix domain sockets
+const unixSocketPrefix = "unix:"
+
+// apiScope is what an API token may do; admin includes read
+type apiScope int
+
+const (
+	scopeRead apiScope = iota + 1
+	scopeAdmin
+)
+
+// apiToken is a credential accepted by the HTTP and gRPC APIs
+type apiToken struct {
+	token string
+	scope apiScope
+}
+
+// The tokens accepted by the APIs, authentication is off when empty
+var apiTokens []apiToken
+
+// parseApiTokens parses "read:<token>" and "admin:<token>" values
+func parseApiTokens(values []string) ([]apiToken, error) {
+	tokens := make([]apiToken, 0, len(values))
+	for _, value := range values {
+		scopeName, token, ok := strings.Cut(value, ":")
+		if !ok || token == "" {
+			return nil, fmt.Errorf("API token must look like read:<token> or admin:<token>")
+		}
+		switch scopeName {
+		case "read":
+			tokens = append(tokens, apiToken{token: token, scope: scopeRead})
+		case "admin":
+			tokens = append(tokens, apiToken{token: token, scope: scopeAdmin})
+		default:
+			return nil, fmt.Errorf("unknown API token scope %q, use read or admin", scopeName)
+		}
+	}
+	return tokens, nil
+}
+
+// authenticate returns the scope of a presented token, comparing every
+// configured token in constant time
+func authenticate(presented string) (apiScope, bool) {
+	var scope apiScope
+	for _, t := range apiTokens {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(t.token)) == 1 {
+			scope = t.scope
+		}
+	}
+	return scope, scope != 0
+}
+
+// presentedToken extracts a bearer token or API key
+func presentedToken(authorization string, apiKey string) string {
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return apiKey
+}
+
+var (
+	errUnauthenticated = errors.New("missing or invalid API token")
+	errForbidden       = errors.New("API token is read-only")
+)
+
+// authorize checks a presented token against the scope an operation needs
+func authorize(presented string, required apiScope) error {
+	if len(apiTokens) == 0 {
+		return nil
+	}
+	scope, ok := authenticate(presented)
+	if !ok {
+		return errUnauthenticated
+	}
+	if scope < required {
+		return errForbidden
+	}
+	return nil
+}
+
+// Scope needed for HTTP routes that only read despite their method
+var httpRouteScopes = map[string]apiScope{
+	"POST /query":       scopeRead,
+	"POST /query/batch": scopeRead,
+}
+
+// httpRouteScope returns the scope a request to a route needs. Everything
+// under /admin/ needs the admin scope, other reads (GET and HEAD) and the
+// routes in httpRouteScopes the read scope, anything else the admin scope.
+func httpRouteScope(method string, route string) apiScope {
+	if strings.HasPrefix(route, "/admin/") {
+		return scopeAdmin
+	}
+	if scope, ok := httpRouteScopes[method+" "+route]; ok {
+		return scope
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return scopeRead
+	}
+	return scopeAdmin
+}
+
+// requireApiToken is the gin middleware authorizing requests with the scope
+// of their route. The health check stays open for container probes.
+func requireApiToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.URL.Path == "/health" {
+			c.Next()
+			return
+		}
+
+		// Unknown routes have no pattern and are checked by their path
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		required := httpRouteScope(c.Request.Method, route)
+
+		token := presentedToken(c.GetHeader("Authorization"), c.GetHeader("X-API-Key"))
+		switch err := authorize(token, required); {
+		case errors.Is(err, errUnauthenticated):
+			c.Header("WWW-Authenticate", `Bearer realm="p2p-rag"`)
+			c.AbortWithStatusJSON(401, gin.H{"error": err.Error()})
+		case err != nil:
+			c.AbortWithStatusJSON(403, gin.H{"error": err.Error()})
+		default:
+			c.Next()
+		}
+	}
+}
+
+// Scope needed for each gRPC method, admin when missing
+var grpcMethodScopes = map[string]apiScope{
+	"/p2prag.control.v1.Control/ListExpertise": scopeRead,
+	"/p2prag.control.v1.Control/ListPeers":     scopeRead,
+	"/p2prag.control.v1.Control/GetPeer":       scopeRead,
+	"/p2prag.control.v1.Control/StreamEvents":  scopeRead,
+}
+
+// authorizeGrpc checks the token sent as "authorization" or "x-api-key" metadata
+func authorizeGrpc(ctx context.Context, method string) error {
+	var authorization, apiKey string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+		if values := md.Get("x-api-key"); len(values) > 0 {
+			apiKey = values[0]
+		}
+	}
+
+	required, ok := grpcMethodScopes[method]
+	if !ok {
+		required = scopeAdmin
+	}
+	switch err := authorize(presentedToken(authorization, apiKey), required); {
+	case errors.Is(err, errUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case err != nil:
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// grpcAuthOptions returns the server options enforcing API tokens and TLS
+func grpcAuthOptions(certFile string, keyFile string) ([]grpc.ServerOption, error) {
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authorizeGrpc(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorizeGrpc(stream.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+	if certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	return options, nil
+}
+
+// listenApi opens a listener for an -api-listen value, either a TCP address
+// or unix:<path>. Sockets are only accessible to the owner and group.
+func listenApi(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, unixSocketPrefix)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	// A socket left behind by a previous run would make Listen fail
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serveApi serves handler on every address until one of them fails. TCP
+// listeners use TLS when a certificate is configured; Unix sockets never do.
+func serveApi(handler http.Handler, addresses []string, certFile string, keyFile string) error {
+	server := &http.Server{Handler: handler}
+	errs := make(chan error, len(addresses))
+	for _, address := range addresses {
+		listener, err := listenApi(address)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", address, err)
+		}
+
+		useTLS := certFile != "" && !strings.HasPrefix(address, unixSocketPrefix)
+		logger.Info("🌐 HTTP API listening on ", address, " (TLS: ", useTLS, ")")
+		go func() {
+			if useTLS {
+				errs <- server.ServeTLS(listener, certFile, keyFile)
+			} else {
+				errs <- server.Serve(listener)
+			}
+		}()
+	}
+	return <-errs
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireApiTokenScopes(t *testing.T) {
+	previous := apiTokens
+	apiTokens = []apiToken{{token: "reader", scope: scopeRead}, {token: "admin", scope: scopeAdmin}}
+	t.Cleanup(func() { apiTokens = previous })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requireApiToken())
+	ok := func(c *gin.Context) { c.Status(200) }
+	r.GET("/health", ok)
+	r.GET("/peers", ok)
+	r.GET("/admin/outbox", ok)
+	r.POST("/admin/outbox/replay", ok)
+	r.POST("/query", ok)
+	r.POST("/query/batch", ok)
+	r.POST("/expertise", ok)
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{method: "GET", path: "/health", status: 200},
+		{method: "GET", path: "/peers", status: 401},
+		{method: "GET", path: "/peers", token: "reader", status: 200},
+		{method: "GET", path: "/admin/outbox", token: "reader", status: 403},
+		{method: "GET", path: "/admin/outbox", token: "admin", status: 200},
+		{method: "POST", path: "/admin/outbox/replay", token: "reader", status: 403},
+		{method: "GET", path: "/admin/unknown", token: "reader", status: 403},
+		{method: "POST", path: "/query", token: "reader", status: 200},
+		{method: "POST", path: "/query/batch", token: "reader", status: 200},
+		{method: "POST", path: "/expertise", token: "reader", status: 403},
+		{method: "POST", path: "/expertise", token: "admin", status: 200},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %s with %q = %d, want %d", test.method, test.path, test.token, rec.Code, test.status)
+		}
+	}
+}
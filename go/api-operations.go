@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// The operations below are shared by the HTTP and the gRPC API, so both
+// validate and behave the same way. They only differ in how requests are
+// decoded and how results and errors are encoded.
+
+// apiError is an error with the HTTP status it is reported with
+type apiError struct {
+	Status  int
+	Message string
+	Details string
+}
+
+func (e *apiError) Error() string {
+	if e.Details == "" {
+		return e.Message
+	}
+	return e.Message + ": " + e.Details
+}
+
+// newApiError creates an error reported with status
+func newApiError(status int, message string, details error) *apiError {
+	e := &apiError{Status: status, Message: message}
+	if details != nil {
+		e.Details = details.Error()
+	}
+	return e
+}
+
+// embeddingApiError reports why text could not be embedded
+func embeddingApiError(err error) *apiError {
+	logger.Warn("❌ Error embedding text:", err)
+	if errors.Is(err, errNoEmbedder) {
+		return newApiError(400, err.Error(), nil)
+	}
+	return newApiError(502, "Failed to embed text", err)
+}
+
+// asApiError converts any error to an apiError, unknown errors being internal
+func asApiError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return newApiError(500, "Internal error", err)
+}
+
+// listExpertise returns the expertise this node announces
+func listExpertise() []Expertise {
+	myExpertiseMutex.RLock()
+	defer myExpertiseMutex.RUnlock()
+
+	topics := make([]Expertise, 0, len(myExpertise))
+	for _, topicData := range myExpertise {
+		topics = append(topics, topicData)
+	}
+	return topics
+}
+
+// EmbeddingInput is an embedding announced through the API. Without a
+// vector, the expertise text is embedded by the node.
+type EmbeddingInput struct {
+	Key       string    `json:"key" binding:"required"`
+	Model     string    `json:"model"`
+	Expertise string    `json:"expertise" binding:"required"`
+	Vector    []float64 `json:"vector"`
+}
+
+// announceExpertise validates, stores and gossips new expertise
+func announceExpertise(ctx context.Context, inputs []EmbeddingInput) (Expertise, error) {
+	if len(inputs) == 0 {
+		return Expertise{}, newApiError(400, "At least one embedding is required", nil)
+	}
+
+	// Embed plain text expertise, then validate vectors
+	embeddings := make([]Embedding, len(inputs))
+	for i, emb := range inputs {
+		if emb.Key == "" || emb.Expertise == "" {
+			return Expertise{}, newApiError(400, "Each embedding needs a key and an expertise", nil)
+		}
+		if len(emb.Vector) == 0 {
+			vector, model, err := embedText(ctx, emb.Expertise)
+			if err != nil {
+				return Expertise{}, embeddingApiError(err)
+			}
+			emb.Vector = vector
+			emb.Model = model
+		} else if emb.Model == "" {
+			return Expertise{}, newApiError(400, "Each embedding with a vector needs a model", nil)
+		} else if len(emb.Vector) != vectorDimension {
+			return Expertise{}, newApiError(400, fmt.Sprintf("Each vector must have exactly %d values", vectorDimension), nil)
+		}
+
+		embeddings[i] = Embedding{
+			Key:       emb.Key,
+			Expertise: emb.Expertise,
+			Model:     emb.Model,
+			Vector:    emb.Vector,
+		}
+	}
+
+	expertiseData := Expertise{
+		Embeddings: embeddings,
+	}
+
+	// Add to known topics
+	myExpertiseMutex.Lock()
+	myExpertise = append(myExpertise, expertiseData)
+	myExpertiseMutex.Unlock()
+
+	// Gossip the new topic immediately
+	if err := publishExpertise(expertiseData); err != nil {
+		return Expertise{}, newApiError(500, "Failed to gossip topic", err)
+	}
+	return expertiseData, nil
+}
+
+// EmbeddingQuery is the search part of a query received through the API
+type EmbeddingQuery struct {
+	ExpertiseKey string      `json:"expertise_key"`
+	Model        string      `json:"model"`
+	Vector       []float64   `json:"vector"`
+	MatchCount   int         `json:"match_count"`
+	Filter       QueryFilter `json:"filter"`
+	QueryText    string      `json:"query_text"`
+}
+
+// QueryInput is a query received through the API
+type QueryInput struct {
+	PeerId    string         `json:"nodeId" binding:"required"`
+	QueryId   string         `json:"queryId" binding:"required"`
+	Question  string         `json:"question"`
+	Embedding EmbeddingQuery `json:"embedding"`
+	Hedge     *bool          `json:"hedge"`
+	Retries   *int           `json:"retries"`
+}
+
+// runQuery answers a query from the local backend when it targets this
+// node, and from the network otherwise. It returns the request sent and
+// the signed response, whose provenance callers pass on.
+func runQuery(ctx context.Context, input QueryInput) (QueryRequest, *QueryResponse, error) {
+	if input.PeerId == "" || input.QueryId == "" {
+		return QueryRequest{}, nil, newApiError(400, "nodeId and queryId are required", nil)
+	}
+
+	// A plain question is embedded by the node
+	if len(input.Embedding.Vector) == 0 && input.Question != "" {
+		vector, model, err := embedText(ctx, input.Question)
+		if err != nil {
+			return QueryRequest{}, nil, embeddingApiError(err)
+		}
+		input.Embedding.Vector = vector
+		input.Embedding.Model = model
+	}
+
+	// Validate vector length
+	if len(input.Embedding.Vector) != vectorDimension {
+		return QueryRequest{}, nil, newApiError(400, fmt.Sprintf("Vector must have exactly %d values", vectorDimension), nil)
+	}
+
+	// Get the host from the global variable
+	if globalHost == nil {
+		return QueryRequest{}, nil, newApiError(500, "P2P host not initialized yet", nil)
+	}
+	vector := Vector(input.Embedding.Vector)
+	recentQueries.Record(vector)
+
+	req := QueryRequest{
+		QueryId:      input.QueryId,
+		ExpertiseKey: input.Embedding.ExpertiseKey,
+		Model:        input.Embedding.Model,
+		MatchCount:   input.Embedding.MatchCount,
+		Vector:       vector,
+		Filter:       input.Embedding.Filter,
+		QueryText:    input.Embedding.QueryText,
+	}
+
+	if input.PeerId == globalHost.ID().String() {
+		if err := validateQueryOptions(req.Filter, req.QueryText); err != nil {
+			return req, nil, newApiError(400, err.Error(), nil)
+		}
+
+		logger.Info("🔍 Querying self")
+		start := time.Now()
+		result, err := searchBackend.Query(ctx, req)
+		audit := QueryAuditEntry{
+			Time:         start,
+			Direction:    queryLocal,
+			QueryId:      req.QueryId,
+			PeerId:       input.PeerId,
+			ExpertiseKey: req.ExpertiseKey,
+			MatchCount:   req.MatchCount,
+			LatencyMs:    time.Since(start).Milliseconds(),
+			ResultCount:  countResultDocuments(result),
+		}
+		if err != nil {
+			audit.ErrorCode = queryErrBackend
+		}
+		queryAudit.Record(audit)
+		if err != nil {
+			logger.Warn("❌ Error querying self:", err)
+			return req, nil, newApiError(500, "Failed to query self", err)
+		}
+
+		response, err := signLocalQueryResult(req, result)
+		if err != nil {
+			logger.Warn("❌ Error signing own result:", err)
+			return req, nil, newApiError(500, "Failed to sign result", err)
+		}
+
+		logger.Info("✅ Successfully queried self")
+		return req, &response, nil
+	}
+
+	logger.Info("🔍 Querying peer:", input.PeerId)
+
+	// Hedging and retries are on by default, callers can opt out per request
+	policy := HedgePolicy{Hedge: true, Retries: queryRetries}
+	if input.Hedge != nil {
+		policy.Hedge = *input.Hedge
+	}
+	if input.Retries != nil {
+		policy.Retries = max(*input.Retries, 0)
+	}
+
+	// Don't wait for a peer that told us its backend is down
+	primary := input.PeerId
+	if peerId, err := peer.Decode(primary); err == nil && !knownExpertise.Available(peerId) {
+		primary = ""
+		if policy.Hedge {
+			primary = nextBestPeer(globalHost, vector, input.PeerId)
+		}
+		if primary == "" {
+			return req, nil, &apiError{Status: 503, Message: "Peer is unavailable", Details: "peer " + input.PeerId + " reported its search backend as unavailable"}
+		}
+		logger.Info("🚦 Peer ", input.PeerId, " is unavailable, querying ", primary, " instead")
+	}
+
+	// Send the query to the remote peer via libp2p
+	response, err := queryWithHedging(ctx, globalHost, primary, req, policy)
+	if err != nil {
+		logger.Warn("❌ Error querying peer:", err)
+		return req, nil, newApiError(500, "Failed to query peer", err)
+	}
+	return req, response, nil
+}
+
+// parsePeerId decodes a peer ID received through the API
+func parsePeerId(id string) (peer.ID, error) {
+	p, err := peer.Decode(id)
+	if err != nil {
+		return "", newApiError(400, "Invalid peer ID", err)
+	}
+	return p, nil
+}
+
+// describePeer returns what we know about the peer with the given ID
+func describePeer(id string) (PeerInfo, error) {
+	p, err := parsePeerId(id)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	if !peerManager.Known(globalHost, p) {
+		return PeerInfo{}, newApiError(404, "Unknown peer", nil)
+	}
+	return peerManager.Describe(globalHost, p), nil
+}
+
+// hostForAdmin returns the libp2p host, which admin operations need
+func hostForAdmin() (host.Host, error) {
+	if globalHost == nil {
+		return nil, newApiError(503, "The libp2p host is not started yet", nil)
+	}
+	return globalHost, nil
+}
+
+// connectPeer connects to a multiaddr ending in /p2p/<peer ID>
+func connectPeer(ctx context.Context, address string) (PeerInfo, error) {
+	h, err := hostForAdmin()
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	addr, err := multiaddr.NewMultiaddr(address)
+	if err != nil {
+		return PeerInfo{}, newApiError(400, "Invalid multiaddr", err)
+	}
+	if _, err := peer.AddrInfoFromP2pAddr(addr); err != nil {
+		return PeerInfo{}, newApiError(400, "The multiaddr must end in /p2p/<peer ID>", err)
+	}
+
+	p, err := peerManager.Connect(ctx, h, addr)
+	if err != nil {
+		if _, banned := peerControls.BannedUntil(p); banned {
+			return PeerInfo{}, newApiError(409, "Peer is banned", nil)
+		}
+		return PeerInfo{}, newApiError(502, "Failed to connect", err)
+	}
+	return peerManager.Describe(h, p), nil
+}
+
+// disconnectPeer closes the connections to a peer
+func disconnectPeer(id string) (PeerInfo, error) {
+	h, err := hostForAdmin()
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	p, err := parsePeerId(id)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	if err := peerManager.Disconnect(h, p); err != nil {
+		return PeerInfo{}, newApiError(500, "Failed to disconnect", err)
+	}
+	return peerManager.Describe(h, p), nil
+}
+
+// banPeer refuses connections with a peer for a duration such as "30m"
+func banPeer(id string, duration string) (PeerInfo, error) {
+	h, err := hostForAdmin()
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	p, err := parsePeerId(id)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	if p == h.ID() {
+		return PeerInfo{}, newApiError(400, "A node cannot ban itself", nil)
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return PeerInfo{}, newApiError(400, "Invalid ban duration", err)
+	}
+	if d <= 0 {
+		return PeerInfo{}, newApiError(400, "Ban duration must be positive", nil)
+	}
+	if _, err := peerManager.Ban(h, p, d); err != nil {
+		return PeerInfo{}, newApiError(500, "Banned, but failed to close connections", err)
+	}
+	return peerManager.Describe(h, p), nil
+}
+
+// unbanPeer lifts the ban of a peer
+func unbanPeer(id string) (PeerInfo, error) {
+	h, err := hostForAdmin()
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	p, err := parsePeerId(id)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	if !peerManager.Unban(p) {
+		return PeerInfo{}, newApiError(404, "Peer is not banned", nil)
+	}
+	return peerManager.Describe(h, p), nil
+}
+
+// protectPeer protects or unprotects a peer from connection pruning
+func protectPeer(id string, protected bool) (PeerInfo, error) {
+	h, err := hostForAdmin()
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	p, err := parsePeerId(id)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	if protected {
+		peerManager.Protect(h, p)
+	} else {
+		peerManager.Unprotect(h, p)
+	}
+	return peerManager.Describe(h, p), nil
+}
+
+// sendMessage delivers a direct message to a peer
+func sendMessage(ctx context.Context, peerId string, body string) (Message, error) {
+	h, err := hostForAdmin()
+	if err != nil {
+		return Message{}, err
+	}
+	p, err := parsePeerId(peerId)
+	if err != nil {
+		return Message{}, err
+	}
+	if p == h.ID() {
+		return Message{}, newApiError(400, "A node cannot message itself", nil)
+	}
+	if err := validateMessageBody(body); err != nil {
+		return Message{}, newApiError(400, err.Error(), nil)
+	}
+
+	message := Message{
+		Id:     generateRandomString(16),
+		From:   h.ID().String(),
+		To:     p.String(),
+		Body:   body,
+		SentAt: time.Now(),
+	}
+	if err := sendMessageToPeer(ctx, h, p, message); err != nil {
+		logger.Warn("❌ Error sending message:", err)
+		return Message{}, newApiError(502, "Failed to deliver message", err)
+	}
+	logger.Info("✉️ Sent message ", message.Id, " to peer: ", p)
+	return message, nil
+}
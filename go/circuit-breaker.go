@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errBackendUnavailable is returned without contacting the backend while the
+// circuit is open
+var errBackendUnavailable = errors.New("search backend is unavailable")
+
+// Timeout of a single health probe
+const healthProbeTimeout = 5 * time.Second
+
+// How long an open circuit fails fast before letting a trial query through
+const breakerRetryAfter = 5 * time.Second
+
+// CircuitBreaker tracks consecutive failures of the search backend. After
+// threshold failures the circuit opens and queries fail fast. Once
+// breakerRetryAfter has passed, the circuit is half-open: a single trial
+// query goes through, and its outcome closes the circuit or reopens it for
+// another breakerRetryAfter. A successful health probe closes it too, while
+// a failed one leaves the wait as it is.
+type CircuitBreaker struct {
+	threshold int
+	failures  int
+	open      bool
+	openedAt  time.Time
+	trial     bool
+	lastError error
+	onChange  func(available bool)
+	mutex     sync.Mutex
+}
+
+// NewCircuitBreaker creates a closed breaker. onChange is called, outside
+// the lock, whenever the circuit opens or closes.
+func NewCircuitBreaker(threshold int, onChange func(available bool)) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: max(threshold, 1),
+		onChange:  onChange,
+	}
+}
+
+// Available reports whether the circuit is closed
+func (cb *CircuitBreaker) Available() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return !cb.open
+}
+
+// Allow returns an error if calls should fail fast, and whether the call is
+// the trial of a half-open circuit. The caller must pass that on when it
+// reports the outcome with Record or Abort.
+func (cb *CircuitBreaker) Allow() (bool, error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if !cb.open {
+		return false, nil
+	}
+	if !cb.trial && time.Since(cb.openedAt) >= breakerRetryAfter {
+		cb.trial = true
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: %w", errBackendUnavailable, cb.lastError)
+}
+
+// Abort gives up a call whose outcome says nothing about the backend
+func (cb *CircuitBreaker) Abort(trial bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if trial {
+		cb.trial = false
+	}
+}
+
+// Record registers the outcome of a call to the backend. Only a failed
+// trial restarts the wait of an open circuit, so failures of other calls
+// cannot keep it open.
+func (cb *CircuitBreaker) Record(trial bool, err error) {
+	cb.mutex.Lock()
+	wasOpen := cb.open
+	if trial {
+		cb.trial = false
+	}
+	if err == nil {
+		cb.failures = 0
+		cb.lastError = nil
+		cb.open = false
+	} else {
+		cb.failures++
+		cb.lastError = err
+		if (!cb.open && cb.failures >= cb.threshold) || (cb.open && trial) {
+			cb.open = true
+			cb.openedAt = time.Now()
+		}
+	}
+	changed := wasOpen != cb.open
+	available := !cb.open
+	cb.mutex.Unlock()
+
+	if changed && cb.onChange != nil {
+		cb.onChange(available)
+	}
+}
+
+// BreakerSearchBackend guards a backend with a circuit breaker
+type BreakerSearchBackend struct {
+	backend SearchBackend
+	breaker *CircuitBreaker
+}
+
+// The breaker guarding searchBackend, set in main
+var backendBreaker *CircuitBreaker
+
+// NewBreakerSearchBackend wraps backend with breaker
+func NewBreakerSearchBackend(backend SearchBackend, breaker *CircuitBreaker) *BreakerSearchBackend {
+	return &BreakerSearchBackend{backend: backend, breaker: breaker}
+}
+
+// Query fails fast while the circuit is open and otherwise records the
+// outcome. Only transport errors and server errors count as failures, a
+// query the backend rejects shows that it is up.
+func (b *BreakerSearchBackend) Query(ctx context.Context, request QueryRequest) (interface{}, error) {
+	trial, err := b.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	result, err := b.backend.Query(ctx, request)
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		// A cancelled caller says nothing about the backend
+		b.breaker.Abort(trial)
+	case errors.Is(err, errInvalidBackendQuery):
+		b.breaker.Abort(trial)
+	case isBackendFailure(err):
+		b.breaker.Record(trial, err)
+	default:
+		b.breaker.Record(trial, nil)
+	}
+	return result, err
+}
+
+// Health probes the backend, even while the circuit is open, so that a
+// recovered backend closes it. Only the periodic probe should call it;
+// requests anyone can make use backendHealth.
+func (b *BreakerSearchBackend) Health(ctx context.Context) error {
+	err := b.backend.Health(ctx)
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		b.breaker.Record(false, err)
+	}
+	return err
+}
+
+// backendHealth checks the search backend without touching the breaker
+func backendHealth(ctx context.Context) error {
+	if b, ok := searchBackend.(*BreakerSearchBackend); ok {
+		return b.backend.Health(ctx)
+	}
+	return searchBackend.Health(ctx)
+}
+
+// Capabilities returns the capabilities of the wrapped backend
+func (b *BreakerSearchBackend) Capabilities() QueryCapabilities {
+	return b.backend.Capabilities()
+}
+
+// probeBackendHealth periodically checks the search backend
+func probeBackendHealth(backend SearchBackend, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+		if err := backend.Health(ctx); err != nil {
+			logger.Warn("❌ Search backend health probe failed:", err)
+		}
+		cancel()
+	}
+}
+
+// localAvailability reports whether this node currently answers queries
+func localAvailability() bool {
+	return backendBreaker == nil || backendBreaker.Available()
+}
+
+// announceAvailability logs a change of the backend's availability and
+// gossips it right away, so peers stop or resume routing to us
+func announceAvailability(available bool) {
+	if available {
+		logger.Info("💚 Search backend recovered, accepting queries again")
+		events.Publish(eventBackendAvailable, "", nil)
+	} else {
+		logger.Warn("💔 Search backend is unavailable, failing queries fast")
+		events.Publish(eventBackendUnavailable, "", nil)
+	}
+	if topic != nil {
+		go gossipTopics(topic)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// openBreaker returns a breaker that opened long enough ago to allow a trial
+func openBreaker(t *testing.T) *CircuitBreaker {
+	cb := NewCircuitBreaker(1, nil)
+	cb.Record(false, errors.New("down"))
+	if cb.Available() {
+		t.Fatal("breaker did not open")
+	}
+	cb.mutex.Lock()
+	cb.openedAt = time.Now().Add(-breakerRetryAfter)
+	cb.mutex.Unlock()
+	return cb
+}
+
+func TestCircuitBreakerFailedProbeKeepsWait(t *testing.T) {
+	cb := openBreaker(t)
+	cb.Record(false, errors.New("still down"))
+	if trial, err := cb.Allow(); err != nil || !trial {
+		t.Errorf("Allow after failed probe = %v, %v, want a trial", trial, err)
+	}
+}
+
+func TestCircuitBreakerSingleTrial(t *testing.T) {
+	cb := openBreaker(t)
+	trial, err := cb.Allow()
+	if err != nil || !trial {
+		t.Fatalf("Allow = %v, %v, want a trial", trial, err)
+	}
+
+	// A probe finishing during the trial must not let another one through
+	cb.Record(false, errors.New("still down"))
+	if _, err := cb.Allow(); !errors.Is(err, errBackendUnavailable) {
+		t.Fatalf("second Allow = %v, want %v", err, errBackendUnavailable)
+	}
+
+	// A failed trial restarts the wait
+	cb.Record(true, errors.New("still down"))
+	if _, err := cb.Allow(); !errors.Is(err, errBackendUnavailable) {
+		t.Errorf("Allow after failed trial = %v, want %v", err, errBackendUnavailable)
+	}
+}
+
+func TestBackendHealthLeavesBreakerAlone(t *testing.T) {
+	cb := NewCircuitBreaker(1, nil)
+	useSearchBackend(t, NewBreakerSearchBackend(&fakeSearchBackend{healthErr: errors.New("down")}, cb))
+
+	if err := backendHealth(context.Background()); err == nil {
+		t.Fatal("backendHealth succeeded on a failing backend")
+	}
+	if !cb.Available() {
+		t.Error("backendHealth opened the circuit")
+	}
+
+	// The periodic probe goes through the breaker
+	if err := searchBackend.Health(context.Background()); err == nil || cb.Available() {
+		t.Errorf("probe = %v, available = %v", err, cb.Available())
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Connection manager tags of peers relevant to our queries and of partners
+const (
+	expertiseTag = "p2p-rag-expertise"
+	partnerTag   = "p2p-rag-partner"
+)
+
+// Highest tag value of a peer whose expertise exactly matches a recent query
+const maxExpertiseTagValue = 100
+
+// Recent queries kept to rate peers, and how long they count
+const (
+	recentQueryCount  = 32
+	recentQueryWindow = 30 * time.Minute
+)
+
+// How often the expertise tags are recomputed
+const expertiseTagInterval = 15 * time.Second
+
+// How often disconnected partners are dialed again
+const partnerRedialInterval = time.Minute
+
+// newConnManager creates the connection manager trimming connections to
+// the low watermark once the high watermark is exceeded
+func newConnManager(config Config) (*connmgr.BasicConnMgr, error) {
+	return connmgr.NewConnManager(config.ConnLowWater, config.ConnHighWater, connmgr.WithGracePeriod(config.ConnGracePeriod))
+}
+
+type recentQuery struct {
+	vector Vector
+	time   time.Time
+}
+
+// RecentQueries keeps the vectors of the last queries this node sent
+type RecentQueries struct {
+	queries []recentQuery
+	mutex   sync.Mutex
+}
+
+// The queries peers are rated against
+var recentQueries = &RecentQueries{}
+
+// Record remembers the vector of a query, dropping the oldest one when full
+func (rq *RecentQueries) Record(vector Vector) {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	rq.queries = append(rq.queries, recentQuery{vector: vector, time: time.Now()})
+	if len(rq.queries) > recentQueryCount {
+		rq.queries = rq.queries[len(rq.queries)-recentQueryCount:]
+	}
+}
+
+// Vectors returns the vectors of the queries inside the window
+func (rq *RecentQueries) Vectors() []Vector {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	cutoff := time.Now().Add(-recentQueryWindow)
+	vectors := make([]Vector, 0, len(rq.queries))
+	for _, query := range rq.queries {
+		if query.time.After(cutoff) {
+			vectors = append(vectors, query.vector)
+		}
+	}
+	return vectors
+}
+
+// expertiseRelevance rates between 0 and 1 how well the best embedding of
+// a peer matches any of the vectors
+func expertiseRelevance(known PeerExpertise, vectors []Vector) float64 {
+	best := 0.0
+	for _, vector := range vectors {
+		for _, embedding := range known.Embeddings {
+			best = max(best, cosineSimilarity(vector[:], embedding.Vector))
+		}
+	}
+	return best
+}
+
+// tagPeersByExpertise tags every peer with how relevant its expertise is to
+// our recent queries, so the connection manager prunes irrelevant peers first
+func tagPeersByExpertise(h host.Host) {
+	tagged := make(map[peer.ID]bool)
+	for {
+		tagged = tagExpertise(h, recentQueries.Vectors(), tagged)
+		time.Sleep(expertiseTagInterval)
+	}
+}
+
+// tagExpertise updates the expertise tags and returns the tagged peers.
+// Peers tagged before that are no longer in the registry, e.g. because
+// their expertise was evicted, are untagged.
+func tagExpertise(h host.Host, vectors []Vector, previous map[peer.ID]bool) map[peer.ID]bool {
+	tagged := make(map[peer.ID]bool)
+	for _, p := range knownExpertise.Peers() {
+		known, ok := knownExpertise.Get(p)
+		if !ok {
+			continue
+		}
+		value := int(math.Round(expertiseRelevance(known, vectors) * maxExpertiseTagValue))
+		if value > 0 {
+			h.ConnManager().TagPeer(p, expertiseTag, value)
+			tagged[p] = true
+		} else {
+			h.ConnManager().UntagPeer(p, expertiseTag)
+		}
+	}
+	for p := range previous {
+		if !tagged[p] {
+			h.ConnManager().UntagPeer(p, expertiseTag)
+		}
+	}
+	return tagged
+}
+
+// keepPartners protects the -partner peers from pruning and dials them
+// whenever they are disconnected
+func keepPartners(h host.Host, partners []multiaddr.Multiaddr) {
+	infos, err := peer.AddrInfosFromP2pAddrs(partners...)
+	if err != nil {
+		logger.Warn("❌ Invalid partner address:", err)
+		return
+	}
+	for _, info := range infos {
+		h.ConnManager().Protect(info.ID, partnerTag)
+	}
+
+	for {
+		for _, info := range infos {
+			if len(h.Network().ConnsToPeer(info.ID)) > 0 {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), restoreDialTimeout)
+			if err := h.Connect(ctx, info); err != nil {
+				logger.Warn("❌ Failed to connect to partner ", info.ID, ":", err)
+			} else {
+				logger.Info("🤝 Connected to partner ", info.ID)
+			}
+			cancel()
+		}
+		time.Sleep(partnerRedialInterval)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/host"
+	basicconnmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+// managedHost adds a real connection manager to a mock host
+type managedHost struct {
+	host.Host
+	cm connmgr.ConnManager
+}
+
+func (h managedHost) ConnManager() connmgr.ConnManager {
+	return h.cm
+}
+
+func TestTagExpertiseUntagsEvictedPeers(t *testing.T) {
+	remote, local := newTestHosts(t)
+	cm, err := basicconnmgr.NewConnManager(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.Close()
+	h := managedHost{Host: local, cm: cm}
+
+	previous := knownExpertise
+	knownExpertise = NewExpertiseRegistry()
+	t.Cleanup(func() { knownExpertise = previous })
+
+	var query Vector
+	query[0] = 1
+	knownExpertise.Update(remote.ID(), Expertise{Embeddings: []Embedding{{Key: "go", Vector: query[:]}}}, nil)
+
+	tagged := tagExpertise(h, []Vector{query}, nil)
+	if got := cm.GetTagInfo(remote.ID()).Tags[expertiseTag]; got != maxExpertiseTagValue {
+		t.Fatalf("tag = %d, want %d", got, maxExpertiseTagValue)
+	}
+
+	// Evict the peer's only embedding, which forgets the peer
+	time.Sleep(time.Millisecond)
+	knownExpertise.Evict(time.Nanosecond)
+	if _, ok := knownExpertise.Get(remote.ID()); ok {
+		t.Fatal("peer still known after eviction")
+	}
+
+	tagged = tagExpertise(h, []Vector{query}, tagged)
+	if info := cm.GetTagInfo(remote.ID()); info != nil {
+		if _, ok := info.Tags[expertiseTag]; ok {
+			t.Errorf("evicted peer is still tagged: %v", info.Tags)
+		}
+	}
+	if len(tagged) != 0 {
+		t.Errorf("tagged peers = %v, want none", tagged)
+	}
+}
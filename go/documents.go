@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// Lines of a bulk NDJSON import may hold large embeddings
+const maxImportLineSize = 16 * 1024 * 1024
+
+// Key of the gossiped embedding recomputed from the stored documents
+const centroidExpertiseKey = "documents_centroid"
+
+// DocumentInput is a document chunk as accepted by the ingestion API
+type DocumentInput struct {
+	Id          string                 `json:"id"`
+	Url         string                 `json:"url"`
+	ChunkNumber int                    `json:"chunk_number"`
+	Title       string                 `json:"title"`
+	Summary     string                 `json:"summary"`
+	Content     string                 `json:"content"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Embedding   []float64              `json:"embedding"`
+}
+
+// ToDocument validates the input and converts it to an index document.
+// Without an explicit ID, the URL and chunk number identify the chunk.
+func (in DocumentInput) ToDocument() (Document, error) {
+	id := in.Id
+	if id == "" {
+		if in.Url == "" {
+			return Document{}, fmt.Errorf("either id or url is required")
+		}
+		id = fmt.Sprintf("%s#%d", in.Url, in.ChunkNumber)
+	}
+	if in.Content == "" {
+		return Document{}, fmt.Errorf("document %s has no content", id)
+	}
+	if len(in.Embedding) != vectorDimension {
+		return Document{}, fmt.Errorf("document %s: embedding must have exactly %d values", id, vectorDimension)
+	}
+	return Document{
+		Id:        id,
+		Title:     in.Title,
+		Summary:   in.Summary,
+		Content:   in.Content,
+		Url:       in.Url,
+		Metadata:  in.Metadata,
+		Embedding: toFloat32(in.Embedding),
+	}, nil
+}
+
+// ingestDocuments stores documents in the memory index
+func ingestDocuments(index *MemoryIndex, inputs []DocumentInput) error {
+	for _, input := range inputs {
+		doc, err := input.ToDocument()
+		if err != nil {
+			return err
+		}
+		if err := index.Upsert(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportError reports a line of a bulk import that could not be stored
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importNDJSON stores one document per line and collects the failing lines
+func importNDJSON(index *MemoryIndex, reader io.Reader) (int, []ImportError, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+
+	imported := 0
+	var failures []ImportError
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var input DocumentInput
+		if err := json.Unmarshal(scanner.Bytes(), &input); err != nil {
+			failures = append(failures, ImportError{Line: line, Error: err.Error()})
+			continue
+		}
+		if err := ingestDocuments(index, []DocumentInput{input}); err != nil {
+			failures = append(failures, ImportError{Line: line, Error: err.Error()})
+			continue
+		}
+		imported++
+	}
+	return imported, failures, scanner.Err()
+}
+
+// importCSV stores the rows of a site_pages export, as found in
+// laravel/dummy-data/site_pages_rows.csv
+func importCSV(index *MemoryIndex, reader io.Reader) (int, error) {
+	rows := csv.NewReader(reader)
+	header, err := rows.Read()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, required := range []string{"content", "embedding"} {
+		if _, ok := columns[required]; !ok {
+			return 0, fmt.Errorf("CSV has no %q column", required)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	imported := 0
+	for line := 2; ; line++ {
+		record, err := rows.Read()
+		if err == io.EOF {
+			return imported, nil
+		}
+		if err != nil {
+			return imported, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		input := DocumentInput{
+			Id:      field(record, "id"),
+			Url:     field(record, "url"),
+			Title:   field(record, "title"),
+			Summary: field(record, "summary"),
+			Content: field(record, "content"),
+		}
+		if chunk := field(record, "chunk_number"); chunk != "" {
+			if input.ChunkNumber, err = strconv.Atoi(chunk); err != nil {
+				return imported, fmt.Errorf("line %d: invalid chunk_number: %w", line, err)
+			}
+		}
+		if metadata := field(record, "metadata"); metadata != "" {
+			if err := json.Unmarshal([]byte(metadata), &input.Metadata); err != nil {
+				return imported, fmt.Errorf("line %d: invalid metadata: %w", line, err)
+			}
+		}
+		// pgvector exports embeddings as "[0.1,0.2,...]", which is valid JSON
+		if err := json.Unmarshal([]byte(field(record, "embedding")), &input.Embedding); err != nil {
+			return imported, fmt.Errorf("line %d: invalid embedding: %w", line, err)
+		}
+
+		if err := ingestDocuments(index, []DocumentInput{input}); err != nil {
+			return imported, fmt.Errorf("line %d: %w", line, err)
+		}
+		imported++
+	}
+}
+
+// documentsCentroid averages the embeddings of all stored documents
+func documentsCentroid(index *MemoryIndex) ([]float64, bool) {
+	docs := index.Documents()
+	if len(docs) == 0 {
+		return nil, false
+	}
+	centroid := make([]float64, vectorDimension)
+	for _, doc := range docs {
+		for i, v := range doc.Embedding {
+			centroid[i] += float64(v)
+		}
+	}
+	for i := range centroid {
+		centroid[i] /= float64(len(docs))
+	}
+	return centroid, true
+}
+
+// recomputeExpertise replaces our centroid embedding with the centroid of
+// the stored documents and gossips our expertise. Expertise announced through
+// the API is kept.
+func recomputeExpertise(index *MemoryIndex) error {
+	centroid, ok := documentsCentroid(index)
+	if !ok {
+		return fmt.Errorf("the index holds no documents")
+	}
+
+	centroidEmbedding := Embedding{
+		Key:       centroidExpertiseKey,
+		Expertise: fmt.Sprintf("centroid of %d documents", index.Len()),
+		Model:     embeddingModel,
+		Vector:    centroid,
+	}
+
+	myExpertiseMutex.Lock()
+	merged := make([]Expertise, 0, len(myExpertise)+1)
+	for _, expertiseData := range myExpertise {
+		embeddings := slices.DeleteFunc(slices.Clone(expertiseData.Embeddings), func(embedding Embedding) bool {
+			return embedding.Key == centroidExpertiseKey
+		})
+		if len(embeddings) > 0 {
+			merged = append(merged, Expertise{Embeddings: embeddings})
+		}
+	}
+	merged = append(merged, Expertise{Embeddings: []Embedding{centroidEmbedding}})
+	myExpertise = merged
+	myExpertiseMutex.Unlock()
+
+	for _, expertiseData := range merged {
+		if err := publishExpertise(expertiseData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runImportCommand implements `p2p-rag import`, which loads a CSV export
+// into the memory index snapshot. The node should not be running meanwhile,
+// since it would overwrite the snapshot with its own copy.
+func runImportCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("file", "", "CSV file to import, e.g. ../laravel/dummy-data/site_pages_rows.csv")
+	dataDir := flags.String("data-dir", "data", "Directory for persistent node state")
+	metric := flags.String("index-metric", metricCosine, "Similarity metric of the memory index: cosine or dot")
+	flags.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+
+	index, err := NewMemoryIndex(vectorDimension, *metric)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dataDir, 0o755); err != nil {
+		return err
+	}
+	snapshotPath := filepath.Join(*dataDir, "index.snapshot")
+	if err := index.LoadSnapshot(snapshotPath); err != nil {
+		return err
+	}
+
+	csvFile, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer csvFile.Close()
+
+	imported, err := importCSV(index, csvFile)
+	if err != nil {
+		return err
+	}
+	if err := index.SaveSnapshot(snapshotPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d documents, the index now holds %d documents\n", imported, index.Len())
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRecomputeExpertiseKeepsAnnouncedExpertise(t *testing.T) {
+	index, err := NewMemoryIndex(vectorDimension, metricCosine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedding := make([]float32, vectorDimension)
+	embedding[0] = 1
+	if err := index.Upsert(Document{Id: "a", Embedding: embedding}); err != nil {
+		t.Fatal(err)
+	}
+
+	myExpertiseMutex.Lock()
+	previous := myExpertise
+	myExpertise = []Expertise{
+		{Embeddings: []Embedding{{Key: "go", Expertise: "Go"}}},
+		{Embeddings: []Embedding{{Key: centroidExpertiseKey, Expertise: "stale"}}},
+	}
+	myExpertiseMutex.Unlock()
+	t.Cleanup(func() {
+		myExpertiseMutex.Lock()
+		myExpertise = previous
+		myExpertiseMutex.Unlock()
+	})
+
+	if err := recomputeExpertise(index); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := map[string]Embedding{}
+	for _, expertise := range listExpertise() {
+		for _, embedding := range expertise.Embeddings {
+			if _, ok := keys[embedding.Key]; ok {
+				t.Errorf("embedding %s is announced twice", embedding.Key)
+			}
+			keys[embedding.Key] = embedding
+		}
+	}
+	if _, ok := keys["go"]; !ok {
+		t.Error("expertise announced through the API was dropped")
+	}
+	if centroid, ok := keys[centroidExpertiseKey]; !ok || centroid.Expertise != "centroid of 1 documents" {
+		t.Errorf("centroid embedding = %+v", centroid)
+	}
+}
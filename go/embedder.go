@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Number of embeddings kept in the cache
+const embeddingCacheSize = 1024
+
+// errNoEmbedder is returned when text is sent but no provider is configured
+var errNoEmbedder = errors.New("no embedding provider configured, set -embedder-url or send a vector")
+
+// Embedder turns text into vectors
+type Embedder interface {
+	// Embed returns the embedding of the text
+	Embed(ctx context.Context, text string) ([]float64, error)
+	// Model returns the name of the embedding model
+	Model() string
+}
+
+// The embedding provider of this node, nil if none is configured
+var embedder Embedder
+
+// newEmbedder creates the embedding provider configured by flags
+func newEmbedder(config Config) Embedder {
+	if config.EmbedderUrl == "" {
+		return nil
+	}
+	return NewCachingEmbedder(NewOllamaEmbedder(config.EmbedderUrl, config.EmbeddingModel), embeddingCacheSize)
+}
+
+// OllamaEmbedder calls the /api/embed endpoint of an Ollama compatible server
+type OllamaEmbedder struct {
+	url    string
+	model  string
+	client *http.Client
+}
+
+// NewOllamaEmbedder creates an embedder for the given server URL and model
+func NewOllamaEmbedder(url string, model string) *OllamaEmbedder {
+	return &OllamaEmbedder{
+		url:    url,
+		model:  model,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Model returns the name of the embedding model
+func (e *OllamaEmbedder) Model() string {
+	return e.model
+}
+
+// Embed asks the server to embed the text
+func (e *OllamaEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	payload, err := json.Marshal(struct {
+		Model string `json:"model"`
+		Input string `json:"input"`
+	}{
+		Model: e.model,
+		Input: text,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+"/api/embed", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedding request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding provider is unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding response: %w", err)
+	}
+
+	var result struct {
+		Embeddings [][]float64 `json:"embeddings"`
+		Error      string      `json:"error"`
+	}
+	// Error responses usually carry a JSON error message, but not always
+	decodeErr := json.Unmarshal(body, &result)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("embedding model %q is not available: %s", e.model, result.Error)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("embedding provider returned error status %d: %s", resp.StatusCode, result.Error)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("failed to parse embedding response: %w", decodeErr)
+	}
+	if len(result.Embeddings) == 0 {
+		return nil, fmt.Errorf("embedding provider returned no embedding")
+	}
+
+	return result.Embeddings[0], nil
+}
+
+// CachingEmbedder keeps the most recently used embeddings in memory
+type CachingEmbedder struct {
+	embedder Embedder
+	size     int
+	entries  map[[32]byte]*list.Element
+	order    *list.List
+	mutex    sync.Mutex
+}
+
+type cachedEmbedding struct {
+	key    [32]byte
+	vector []float64
+}
+
+// NewCachingEmbedder wraps an embedder with an LRU cache of the given size
+func NewCachingEmbedder(embedder Embedder, size int) *CachingEmbedder {
+	return &CachingEmbedder{
+		embedder: embedder,
+		size:     size,
+		entries:  make(map[[32]byte]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Model returns the name of the wrapped embedding model
+func (ce *CachingEmbedder) Model() string {
+	return ce.embedder.Model()
+}
+
+// Embed returns a cached embedding or computes and caches it
+func (ce *CachingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	key := sha256.Sum256([]byte(ce.embedder.Model() + "\x00" + text))
+
+	ce.mutex.Lock()
+	if element, ok := ce.entries[key]; ok {
+		ce.order.MoveToFront(element)
+		vector := element.Value.(*cachedEmbedding).vector
+		ce.mutex.Unlock()
+		return vector, nil
+	}
+	ce.mutex.Unlock()
+
+	vector, err := ce.embedder.Embed(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	if _, ok := ce.entries[key]; !ok {
+		ce.entries[key] = ce.order.PushFront(&cachedEmbedding{key: key, vector: vector})
+		if ce.order.Len() > ce.size {
+			oldest := ce.order.Back()
+			ce.order.Remove(oldest)
+			delete(ce.entries, oldest.Value.(*cachedEmbedding).key)
+		}
+	}
+	return vector, nil
+}
+
+// embedText embeds text with the node's provider and checks the dimension
+func embedText(ctx context.Context, text string) ([]float64, string, error) {
+	if embedder == nil {
+		return nil, "", errNoEmbedder
+	}
+	vector, err := embedder.Embed(ctx, text)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(vector) != vectorDimension {
+		return nil, "", fmt.Errorf("embedding model %q returned %d values, expected %d", embedder.Model(), len(vector), vectorDimension)
+	}
+	return vector, embedder.Model(), nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events buffered per subscriber; slower subscribers miss events
+const eventBufferSize = 64
+
+// Interval between comments sent on an idle event stream
+const eventKeepaliveInterval = 15 * time.Second
+
+// Types of node events, besides the expertise notification events
+const (
+	eventPeerConnected      = "peer.connected"
+	eventPeerDisconnected   = "peer.disconnected"
+	eventPeerIdentified     = "peer.identified"
+	eventExpertiseReceived  = "expertise.received"
+	eventExpertiseEvicted   = "expertise.evicted"
+	eventQueryServed        = "query.served"
+	eventQueryCompleted     = "query.completed"
+	eventBackendAvailable   = "backend.available"
+	eventBackendUnavailable = "backend.unavailable"
+	eventMessageReceived    = "message.received"
+)
+
+// Every event type subscribers can ask for
+var knownEventTypes = []string{
+	eventPeerConnected, eventPeerDisconnected, eventPeerIdentified,
+	eventExpertiseReceived, expertiseAdded, expertiseChanged, expertiseRemoved, eventExpertiseEvicted,
+	nodeAvailable, nodeUnavailable,
+	eventQueryServed, eventQueryCompleted,
+	eventBackendAvailable, eventBackendUnavailable,
+	eventMessageReceived,
+}
+
+// parseEventTypes splits comma separated event types and rejects unknown ones
+func parseEventTypes(values []string) ([]string, error) {
+	var types []string
+	for _, value := range values {
+		for _, eventType := range strings.Split(value, ",") {
+			eventType = strings.TrimSpace(eventType)
+			if eventType == "" {
+				continue
+			}
+			if !slices.Contains(knownEventTypes, eventType) {
+				return nil, fmt.Errorf("unknown event type %q", eventType)
+			}
+			types = append(types, eventType)
+		}
+	}
+	return types, nil
+}
+
+// NodeEvent is something that happened on this node
+type NodeEvent struct {
+	Type   string      `json:"type"`
+	Time   time.Time   `json:"time"`
+	PeerId string      `json:"nodeId,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// EventBus fans node events out to subscribers
+type EventBus struct {
+	subscribers map[chan NodeEvent][]string
+	mutex       sync.RWMutex
+}
+
+// The events of this node
+var events = NewEventBus()
+
+// NewEventBus creates a bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan NodeEvent][]string),
+	}
+}
+
+// Subscribe returns a channel receiving events of the given types, or all
+// events when types is empty, and a function to unsubscribe
+func (eb *EventBus) Subscribe(types []string) (<-chan NodeEvent, func()) {
+	ch := make(chan NodeEvent, eventBufferSize)
+
+	eb.mutex.Lock()
+	eb.subscribers[ch] = types
+	eb.mutex.Unlock()
+
+	return ch, func() {
+		eb.mutex.Lock()
+		defer eb.mutex.Unlock()
+		if _, ok := eb.subscribers[ch]; ok {
+			delete(eb.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish sends an event to the interested subscribers without blocking
+func (eb *EventBus) Publish(eventType string, peerId string, data interface{}) {
+	event := NodeEvent{Type: eventType, Time: time.Now(), PeerId: peerId, Data: data}
+
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+	for ch, types := range eb.subscribers {
+		if len(types) > 0 && !slices.Contains(types, eventType) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// publishPeerEvents publishes the peer lifecycle changes of pm as node
+// events. It subscribes right away, so call it before starting pm.
+func publishPeerEvents(pm *PeerManager) {
+	changes, _ := pm.Subscribe()
+	go func() {
+		for change := range changes {
+			peerId := change.State.Id.String()
+			switch change.Type {
+			case eventPeerConnected:
+				events.Publish(change.Type, peerId, map[string]interface{}{
+					"address":   change.Address.String(),
+					"direction": change.State.Direction.String(),
+				})
+			case eventPeerIdentified:
+				events.Publish(change.Type, peerId, map[string]interface{}{
+					"agent_version": change.State.AgentVersion,
+					"protocols":     change.State.Protocols,
+				})
+			default:
+				events.Publish(change.Type, peerId, nil)
+			}
+		}
+	}()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Event types of expertise notifications sent to the client API
+const (
+	expertiseAdded   = "expertise.added"
+	expertiseChanged = "expertise.changed"
+	expertiseRemoved = "expertise.removed"
+	nodeAvailable    = "node.available"
+	nodeUnavailable  = "node.unavailable"
+)
+
+// ExpertiseNotification is the payload posted to the client API's /expertise
+// endpoint. Removed embeddings carry their last known content.
+type ExpertiseNotification struct {
+	Event        string             `json:"event"`
+	NodeId       string             `json:"nodeId"`
+	Embeddings   []Embedding        `json:"embeddings,omitempty"`
+	Capabilities *QueryCapabilities `json:"capabilities,omitempty"`
+	Available    *bool              `json:"available,omitempty"`
+}
+
+// expertiseChange is the net change of one embedding within a debounce window
+type expertiseChange struct {
+	event     string
+	embedding Embedding
+}
+
+// ExpertiseNotifier batches expertise changes per peer and queues one
+// notification per peer and event type when the debounce window closes
+type ExpertiseNotifier struct {
+	window       time.Duration
+	pending      map[peer.ID]map[string]expertiseChange
+	capabilities map[peer.ID]*QueryCapabilities
+	timer        *time.Timer
+	mutex        sync.Mutex
+}
+
+// The notifier fed by gossip, set in main
+var expertiseNotifier *ExpertiseNotifier
+
+// NewExpertiseNotifier creates a notifier batching changes during window
+func NewExpertiseNotifier(window time.Duration) *ExpertiseNotifier {
+	return &ExpertiseNotifier{
+		window:       window,
+		pending:      make(map[peer.ID]map[string]expertiseChange),
+		capabilities: make(map[peer.ID]*QueryCapabilities),
+	}
+}
+
+// Record adds changes of a peer's expertise to the current window. Changes
+// to the same embedding are coalesced: an embedding added and removed within
+// the window is not reported at all.
+func (n *ExpertiseNotifier) Record(p peer.ID, added, changed, removed []Embedding, capabilities *QueryCapabilities) {
+	if len(added)+len(changed)+len(removed) == 0 {
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	changes, ok := n.pending[p]
+	if !ok {
+		changes = make(map[string]expertiseChange)
+		n.pending[p] = changes
+	}
+	for _, embedding := range added {
+		event := expertiseAdded
+		// Removed and announced again within the window
+		if previous, ok := changes[embedding.Key]; ok && previous.event == expertiseRemoved {
+			event = expertiseChanged
+		}
+		changes[embedding.Key] = expertiseChange{event: event, embedding: embedding}
+	}
+	for _, embedding := range changed {
+		event := expertiseChanged
+		// Still new to the client API
+		if previous, ok := changes[embedding.Key]; ok && previous.event == expertiseAdded {
+			event = expertiseAdded
+		}
+		changes[embedding.Key] = expertiseChange{event: event, embedding: embedding}
+	}
+	for _, embedding := range removed {
+		if previous, ok := changes[embedding.Key]; ok && previous.event == expertiseAdded {
+			delete(changes, embedding.Key)
+			continue
+		}
+		changes[embedding.Key] = expertiseChange{event: expertiseRemoved, embedding: embedding}
+	}
+	if capabilities != nil {
+		n.capabilities[p] = capabilities
+	}
+
+	if n.timer == nil {
+		n.timer = time.AfterFunc(n.window, n.flush)
+	}
+}
+
+// flush queues the notifications of the closed window
+func (n *ExpertiseNotifier) flush() {
+	n.mutex.Lock()
+	pending, capabilities := n.pending, n.capabilities
+	n.pending = make(map[peer.ID]map[string]expertiseChange)
+	n.capabilities = make(map[peer.ID]*QueryCapabilities)
+	n.timer = nil
+	n.mutex.Unlock()
+
+	for p, changes := range pending {
+		byEvent := make(map[string][]Embedding)
+		for _, change := range changes {
+			byEvent[change.event] = append(byEvent[change.event], change.embedding)
+		}
+		for _, event := range []string{expertiseAdded, expertiseChanged, expertiseRemoved} {
+			embeddings := byEvent[event]
+			if len(embeddings) == 0 {
+				continue
+			}
+			sort.Slice(embeddings, func(i, j int) bool { return embeddings[i].Key < embeddings[j].Key })
+			notifyExternalApiAboutExpertise(ExpertiseNotification{
+				Event:        event,
+				NodeId:       p.String(),
+				Embeddings:   embeddings,
+				Capabilities: capabilities[p],
+			})
+		}
+	}
+}
+
+// embeddingKeys lists the keys of embeddings, for events that leave out vectors
+func embeddingKeys(embeddings []Embedding) []string {
+	keys := make([]string, len(embeddings))
+	for i, embedding := range embeddings {
+		keys[i] = embedding.Key
+	}
+	return keys
+}
+
+// evictStaleExpertise periodically drops embeddings peers stopped announcing
+// and reports them as removed
+func evictStaleExpertise(ttl time.Duration) {
+	ticker := time.NewTicker(min(ttl/2, 10*time.Second))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for p, removed := range knownExpertise.Evict(ttl) {
+			logger.Info("🧹 Evicted ", len(removed), " stale embeddings of peer ", p)
+			events.Publish(eventExpertiseEvicted, p.String(), map[string]interface{}{"keys": embeddingKeys(removed)})
+			expertiseNotifier.Record(p, nil, nil, removed, nil)
+		}
+	}
+}
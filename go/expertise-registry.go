@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PeerExpertise is what we learned about a peer from its gossip
+type PeerExpertise struct {
+	Embeddings   map[string]Embedding `json:"embeddings"`
+	Capabilities *QueryCapabilities   `json:"capabilities,omitempty"`
+	LastSeen     time.Time            `json:"last_seen"`
+	// Whether the peer's search backend currently answers queries
+	Unavailable bool `json:"unavailable,omitempty"`
+
+	// When each embedding was last announced
+	seen map[string]time.Time
+}
+
+// PeerMatch is a peer ranked by how well its expertise matches a vector
+type PeerMatch struct {
+	Peer       peer.ID
+	Similarity float64
+}
+
+// ExpertiseRegistry keeps the expertise gossiped by other peers
+type ExpertiseRegistry struct {
+	peers map[peer.ID]*PeerExpertise
+	mutex sync.RWMutex
+}
+
+// NewExpertiseRegistry initializes an empty registry
+func NewExpertiseRegistry() *ExpertiseRegistry {
+	return &ExpertiseRegistry{
+		peers: make(map[peer.ID]*PeerExpertise),
+	}
+}
+
+// Update merges an announcement into what we know about the peer and
+// returns the embeddings that are new or differ from what we had. Peers
+// announce their embeddings in several messages, so embeddings are merged by key.
+func (er *ExpertiseRegistry) Update(p peer.ID, expertise Expertise, capabilities *QueryCapabilities) (added []Embedding, changed []Embedding) {
+	er.mutex.Lock()
+	defer er.mutex.Unlock()
+
+	now := time.Now()
+	known, ok := er.peers[p]
+	if !ok {
+		known = &PeerExpertise{Embeddings: make(map[string]Embedding), seen: make(map[string]time.Time)}
+		er.peers[p] = known
+	}
+	for _, embedding := range expertise.Embeddings {
+		previous, exists := known.Embeddings[embedding.Key]
+		switch {
+		case !exists:
+			added = append(added, embedding)
+		case !sameEmbedding(previous, embedding):
+			changed = append(changed, embedding)
+		}
+		known.Embeddings[embedding.Key] = embedding
+		known.seen[embedding.Key] = now
+	}
+	if capabilities != nil {
+		known.Capabilities = capabilities
+	}
+	known.LastSeen = now
+	return added, changed
+}
+
+// Evict drops embeddings that were not announced again within ttl and
+// returns them per peer. Peers without embeddings left are forgotten.
+func (er *ExpertiseRegistry) Evict(ttl time.Duration) map[peer.ID][]Embedding {
+	er.mutex.Lock()
+	defer er.mutex.Unlock()
+
+	evicted := make(map[peer.ID][]Embedding)
+	deadline := time.Now().Add(-ttl)
+	for p, known := range er.peers {
+		for key, seen := range known.seen {
+			if seen.Before(deadline) {
+				evicted[p] = append(evicted[p], known.Embeddings[key])
+				delete(known.Embeddings, key)
+				delete(known.seen, key)
+			}
+		}
+		if len(known.Embeddings) == 0 {
+			delete(er.peers, p)
+		}
+	}
+	return evicted
+}
+
+// SetAvailable records the availability a peer gossiped and reports whether
+// it changed
+func (er *ExpertiseRegistry) SetAvailable(p peer.ID, available bool) bool {
+	er.mutex.Lock()
+	defer er.mutex.Unlock()
+
+	known, ok := er.peers[p]
+	if !ok || known.Unavailable == !available {
+		return false
+	}
+	known.Unavailable = !available
+	return true
+}
+
+// Available reports whether a peer can be routed to. Unknown peers are
+// assumed to be available.
+func (er *ExpertiseRegistry) Available(p peer.ID) bool {
+	er.mutex.RLock()
+	defer er.mutex.RUnlock()
+
+	known, ok := er.peers[p]
+	return !ok || !known.Unavailable
+}
+
+// sameEmbedding reports whether an announcement repeats an embedding unchanged
+func sameEmbedding(a, b Embedding) bool {
+	return a.Key == b.Key && a.Expertise == b.Expertise && a.Model == b.Model && slices.Equal(a.Vector, b.Vector)
+}
+
+// Get returns a copy of what we know about a peer
+func (er *ExpertiseRegistry) Get(p peer.ID) (PeerExpertise, bool) {
+	er.mutex.RLock()
+	defer er.mutex.RUnlock()
+
+	known, ok := er.peers[p]
+	if !ok {
+		return PeerExpertise{}, false
+	}
+	embeddings := make(map[string]Embedding, len(known.Embeddings))
+	for key, embedding := range known.Embeddings {
+		embeddings[key] = embedding
+	}
+	return PeerExpertise{Embeddings: embeddings, Capabilities: known.Capabilities, LastSeen: known.LastSeen, Unavailable: known.Unavailable}, true
+}
+
+// Peers returns the peers we know expertise of
+func (er *ExpertiseRegistry) Peers() []peer.ID {
+	er.mutex.RLock()
+	defer er.mutex.RUnlock()
+
+	peers := make([]peer.ID, 0, len(er.peers))
+	for p := range er.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Match ranks available peers by the best cosine similarity between the
+// vector and any of their embeddings, weighted by their reputation
+func (er *ExpertiseRegistry) Match(vector Vector) []PeerMatch {
+	er.mutex.RLock()
+	matches := make([]PeerMatch, 0, len(er.peers))
+	for p, known := range er.peers {
+		if known.Unavailable {
+			continue
+		}
+		best := math.Inf(-1)
+		for _, embedding := range known.Embeddings {
+			best = max(best, cosineSimilarity(vector[:], embedding.Vector))
+		}
+		if len(known.Embeddings) > 0 {
+			matches = append(matches, PeerMatch{Peer: p, Similarity: best})
+		}
+	}
+	er.mutex.RUnlock()
+
+	rank := make(map[peer.ID]float64, len(matches))
+	for _, match := range matches {
+		rank[match.Peer] = match.Similarity * (0.5 + 0.5*reputation.Score(match.Peer))
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return rank[matches[i].Peer] > rank[matches[j].Peer]
+	})
+	return matches
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Protocol ID for sending relevance feedback to the answering peer
+const feedbackProtocolID = "/p2p-rag/feedback/0.0.1"
+
+// Relevance ratings range from 1 (useless) to 5 (exactly what was needed)
+const (
+	minFeedbackRating = 1
+	maxFeedbackRating = 5
+)
+
+// Feedback rates one document returned for a query
+type Feedback struct {
+	QueryId      string    `json:"queryId"`
+	DocumentId   string    `json:"documentId"`
+	PeerId       string    `json:"nodeId"`
+	ExpertiseKey string    `json:"expertise_key,omitempty"`
+	Rating       int       `json:"rating"`
+	From         string    `json:"from,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// Validate checks the fields every feedback entry needs
+func (f Feedback) Validate() error {
+	if f.QueryId == "" || f.DocumentId == "" || f.PeerId == "" {
+		return fmt.Errorf("queryId, documentId and nodeId are required")
+	}
+	if f.Rating < minFeedbackRating || f.Rating > maxFeedbackRating {
+		return fmt.Errorf("rating must be between %d and %d", minFeedbackRating, maxFeedbackRating)
+	}
+	return nil
+}
+
+// FeedbackAggregate summarizes the feedback for one peer and expertise key
+type FeedbackAggregate struct {
+	PeerId       string  `json:"nodeId"`
+	ExpertiseKey string  `json:"expertise_key"`
+	Count        int     `json:"count"`
+	AvgRating    float64 `json:"avg_rating"`
+}
+
+type feedbackKey struct {
+	peerId       string
+	expertiseKey string
+}
+
+// feedbackQueryKey identifies the feedback one peer gave on one query
+type feedbackQueryKey struct {
+	from    string
+	peerId  string
+	queryId string
+}
+
+var (
+	// errUnansweredFeedbackQuery rejects feedback on a query the audit log
+	// shows no answer for
+	errUnansweredFeedbackQuery = errors.New("no answered query matches the feedback")
+	// errDuplicateFeedback rejects a second rating of the same document
+	errDuplicateFeedback = errors.New("feedback was already given on this document")
+	// errTooMuchFeedback rejects ratings of more documents than the query returned
+	errTooMuchFeedback = errors.New("feedback was already given on every document the query returned")
+)
+
+// FeedbackStore appends feedback to a file and keeps per peer and expertise
+// key aggregates in memory, along with the documents rated per query
+type FeedbackStore struct {
+	path       string
+	aggregates map[feedbackKey]*FeedbackAggregate
+	rated      map[feedbackQueryKey][]string
+	mutex      sync.Mutex
+}
+
+// NewFeedbackStore loads the feedback stored at path, if any
+func NewFeedbackStore(path string) (*FeedbackStore, error) {
+	fs := &FeedbackStore{
+		path:       path,
+		aggregates: make(map[feedbackKey]*FeedbackAggregate),
+		rated:      make(map[feedbackQueryKey][]string),
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var feedback Feedback
+		if err := json.Unmarshal(scanner.Bytes(), &feedback); err != nil {
+			logger.Warn("❌ Skipping malformed feedback entry:", err)
+			continue
+		}
+		fs.aggregate(feedback)
+	}
+	return fs, scanner.Err()
+}
+
+func (fs *FeedbackStore) aggregate(feedback Feedback) {
+	key := feedbackKey{peerId: feedback.PeerId, expertiseKey: feedback.ExpertiseKey}
+	agg, ok := fs.aggregates[key]
+	if !ok {
+		agg = &FeedbackAggregate{PeerId: feedback.PeerId, ExpertiseKey: feedback.ExpertiseKey}
+		fs.aggregates[key] = agg
+	}
+	agg.Count++
+	agg.AvgRating += (float64(feedback.Rating) - agg.AvgRating) / float64(agg.Count)
+
+	queryKey := feedbackQueryKey{from: feedback.From, peerId: feedback.PeerId, queryId: feedback.QueryId}
+	fs.rated[queryKey] = append(fs.rated[queryKey], feedback.DocumentId)
+}
+
+// Check tells whether Add would accept a feedback entry
+func (fs *FeedbackStore) Check(feedback Feedback, documentCount int) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.check(feedback, documentCount)
+}
+
+// check enforces that each peer rates a document of a query once, and rates
+// at most documentCount documents of it; callers must hold the mutex
+func (fs *FeedbackStore) check(feedback Feedback, documentCount int) error {
+	rated := fs.rated[feedbackQueryKey{from: feedback.From, peerId: feedback.PeerId, queryId: feedback.QueryId}]
+	if slices.Contains(rated, feedback.DocumentId) {
+		return errDuplicateFeedback
+	}
+	if len(rated) >= documentCount {
+		return errTooMuchFeedback
+	}
+	return nil
+}
+
+// Add stores a feedback entry if check accepts it
+func (fs *FeedbackStore) Add(feedback Feedback, documentCount int) error {
+	data, err := json.Marshal(feedback)
+	if err != nil {
+		return err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if err := fs.check(feedback, documentCount); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(fs.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	fs.aggregate(feedback)
+	return nil
+}
+
+// Aggregates returns the feedback summaries sorted by peer and expertise key
+func (fs *FeedbackStore) Aggregates() []FeedbackAggregate {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	aggregates := make([]FeedbackAggregate, 0, len(fs.aggregates))
+	for _, agg := range fs.aggregates {
+		aggregates = append(aggregates, *agg)
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		if aggregates[i].PeerId != aggregates[j].PeerId {
+			return aggregates[i].PeerId < aggregates[j].PeerId
+		}
+		return aggregates[i].ExpertiseKey < aggregates[j].ExpertiseKey
+	})
+	return aggregates
+}
+
+// answeredDocuments returns how many documents the successful audit entries
+// of a query with a peer returned in the given directions. Batches are
+// audited per item, so their counts are summed.
+func answeredDocuments(peerId string, queryId string, directions ...string) (int, error) {
+	entries, err := queryAudit.Query(queryId)
+	if err != nil {
+		return 0, err
+	}
+	documents, answered := 0, false
+	for _, entry := range entries {
+		if entry.PeerId == peerId && entry.ErrorCode == "" && slices.Contains(directions, entry.Direction) {
+			documents += entry.ResultCount
+			answered = true
+		}
+	}
+	if !answered {
+		return 0, errUnansweredFeedbackQuery
+	}
+	return documents, nil
+}
+
+// FeedbackAck acknowledges feedback sent over the feedback protocol
+type FeedbackAck struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// setupFeedbackProtocol initializes the feedback protocol handler
+func setupFeedbackProtocol(host host.Host) {
+	host.SetStreamHandler(protocol.ID(feedbackProtocolID), handleFeedbackStream)
+}
+
+// handleFeedbackStream stores feedback other peers give on our answers
+func handleFeedbackStream(stream network.Stream) {
+	defer stream.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	reply := func(ack FeedbackAck) {
+		if err := json.NewEncoder(rw.Writer).Encode(ack); err != nil {
+			logger.Warn("❌ Error encoding feedback ack:", err)
+			return
+		}
+		if err := rw.Writer.Flush(); err != nil {
+			logger.Warn("❌ Error flushing feedback ack:", err)
+		}
+	}
+
+	var feedback Feedback
+	if err := json.NewDecoder(rw.Reader).Decode(&feedback); err != nil {
+		logger.Warn("❌ Error decoding feedback:", err)
+		reply(FeedbackAck{Success: false, Error: "Failed to decode feedback"})
+		return
+	}
+
+	if err := feedback.Validate(); err != nil {
+		reply(FeedbackAck{Success: false, Error: err.Error()})
+		return
+	}
+	if feedback.PeerId != stream.Conn().LocalPeer().String() {
+		reply(FeedbackAck{Success: false, Error: "Feedback is not about this node"})
+		return
+	}
+
+	// Trust the connection rather than the payload about who sent it
+	feedback.From = stream.Conn().RemotePeer().String()
+	feedback.Time = time.Now().UTC()
+
+	// Only the peer we answered the query for may rate the answer
+	documents, err := answeredDocuments(feedback.From, feedback.QueryId, queryInbound)
+	if errors.Is(err, errUnansweredFeedbackQuery) {
+		reply(FeedbackAck{Success: false, Error: err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Warn("❌ Error reading query audit log:", err)
+		reply(FeedbackAck{Success: false, Error: "Failed to check the query"})
+		return
+	}
+
+	err = receivedFeedback.Add(feedback, documents)
+	if errors.Is(err, errDuplicateFeedback) || errors.Is(err, errTooMuchFeedback) {
+		reply(FeedbackAck{Success: false, Error: err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Warn("❌ Error storing received feedback:", err)
+		reply(FeedbackAck{Success: false, Error: "Failed to store feedback"})
+		return
+	}
+
+	logger.Info("📥 Received feedback from peer:", feedback.From, " for query ", feedback.QueryId)
+	reply(FeedbackAck{Success: true})
+}
+
+// sendFeedbackToPeer sends feedback to the peer that produced the rated document
+func sendFeedbackToPeer(ctx context.Context, host host.Host, feedback Feedback) error {
+	peerID, err := peer.Decode(feedback.PeerId)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	if host.Network().Connectedness(peerID) != network.Connected {
+		return fmt.Errorf("not connected to peer %s", feedback.PeerId)
+	}
+
+	stream, err := host.NewStream(ctx, peerID, protocol.ID(feedbackProtocolID))
+	if err != nil {
+		return fmt.Errorf("failed to open stream to peer: %w", err)
+	}
+	defer stream.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+
+	if err := json.NewEncoder(rw.Writer).Encode(feedback); err != nil {
+		return fmt.Errorf("failed to encode feedback: %w", err)
+	}
+	if err := rw.Writer.Flush(); err != nil {
+		return fmt.Errorf("failed to send feedback: %w", err)
+	}
+
+	var ack FeedbackAck
+	if err := json.NewDecoder(rw.Reader).Decode(&ack); err != nil {
+		return fmt.Errorf("failed to decode feedback ack: %w", err)
+	}
+	if !ack.Success {
+		return fmt.Errorf("feedback rejected by peer: %s", ack.Error)
+	}
+
+	logger.Info("📤 Sent feedback to peer:", peerID)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// useReceivedFeedback stores received feedback in a fresh file for the rest of the test
+func useReceivedFeedback(t *testing.T) *FeedbackStore {
+	store, err := NewFeedbackStore(filepath.Join(t.TempDir(), "feedback-received.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := receivedFeedback
+	receivedFeedback = store
+	t.Cleanup(func() { receivedFeedback = previous })
+	return store
+}
+
+func TestHandleFeedbackStream(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupFeedbackProtocol(local)
+	audit := useQueryAudit(t)
+	store := useReceivedFeedback(t)
+
+	audit.Record(
+		QueryAuditEntry{Direction: queryInbound, QueryId: "answered", PeerId: remote.ID().String(), ResultCount: 2},
+		QueryAuditEntry{Direction: queryInbound, QueryId: "failed", PeerId: remote.ID().String(), ErrorCode: queryErrBackend},
+		QueryAuditEntry{Direction: queryInbound, QueryId: "other", PeerId: local.ID().String(), ResultCount: 2},
+		QueryAuditEntry{Direction: queryOutbound, QueryId: "sent", PeerId: remote.ID().String(), ResultCount: 2},
+	)
+
+	send := func(queryId string, documentId string) FeedbackAck {
+		feedback := Feedback{QueryId: queryId, DocumentId: documentId, PeerId: local.ID().String(), Rating: 4}
+		var ack FeedbackAck
+		exchange(t, remote, local, feedbackProtocolID, feedback, &ack)
+		return ack
+	}
+
+	tests := []struct {
+		name       string
+		queryId    string
+		documentId string
+		err        error
+	}{
+		{name: "unknown query", queryId: "unknown", documentId: "a", err: errUnansweredFeedbackQuery},
+		{name: "failed query", queryId: "failed", documentId: "a", err: errUnansweredFeedbackQuery},
+		{name: "query of another peer", queryId: "other", documentId: "a", err: errUnansweredFeedbackQuery},
+		{name: "query we sent", queryId: "sent", documentId: "a", err: errUnansweredFeedbackQuery},
+		{name: "answered query", queryId: "answered", documentId: "a"},
+		{name: "same document again", queryId: "answered", documentId: "a", err: errDuplicateFeedback},
+		{name: "second document", queryId: "answered", documentId: "b"},
+		{name: "more documents than returned", queryId: "answered", documentId: "c", err: errTooMuchFeedback},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ack := send(test.queryId, test.documentId)
+			if test.err == nil && !ack.Success {
+				t.Fatalf("feedback rejected: %s", ack.Error)
+			}
+			if test.err != nil && (ack.Success || ack.Error != test.err.Error()) {
+				t.Fatalf("ack = %+v, want error %q", ack, test.err)
+			}
+		})
+	}
+
+	aggregates := store.Aggregates()
+	if len(aggregates) != 1 || aggregates[0].Count != 2 {
+		t.Errorf("aggregates = %+v, want 2 ratings", aggregates)
+	}
+}
+
+func TestFeedbackStoreDedupeSurvivesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	store, err := NewFeedbackStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedback := Feedback{QueryId: "q1", DocumentId: "a", PeerId: "peer", From: "sender", Rating: 5}
+	if err := store.Add(feedback, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Checking does not store anything, so a failed share can be retried
+	unshared := Feedback{QueryId: "q1", DocumentId: "b", PeerId: "peer", From: "sender", Rating: 5}
+	for range 2 {
+		if err := store.Check(unshared, 2); err != nil {
+			t.Fatalf("Check = %v", err)
+		}
+	}
+
+	reloaded, err := NewFeedbackStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Add(feedback, 1); !errors.Is(err, errDuplicateFeedback) {
+		t.Errorf("Add after reload = %v, want %v", err, errDuplicateFeedback)
+	}
+
+	// Another peer rating the same query is counted separately
+	feedback.From = "other"
+	if err := reloaded.Add(feedback, 1); err != nil {
+		t.Errorf("Add from another peer = %v", err)
+	}
+}
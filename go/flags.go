@@ -2,9 +2,12 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"strings"
+	"time"
 
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
 	maddr "github.com/multiformats/go-multiaddr"
 )
 
@@ -28,6 +31,18 @@ func (al *addrList) Set(value string) error {
 	return nil
 }
 
+// stringList collects the values of a repeatable flag
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
 func StringsToAddrs(addrStrings []string) (maddrs []maddr.Multiaddr, err error) {
 	for _, addrString := range addrStrings {
 		addr, err := maddr.NewMultiaddr(addrString)
@@ -40,12 +55,37 @@ func StringsToAddrs(addrStrings []string) (maddrs []maddr.Multiaddr, err error)
 }
 
 type Config struct {
-	RendezvousString string
-	BootstrapPeers   addrList
-	ListenAddresses  addrList
-	ProtocolID       string
-	PrivateKey       string
-	ClientApiUrl     string
+	RendezvousString  string
+	BootstrapPeers    addrList
+	ListenAddresses   addrList
+	PrivateKey        string
+	ClientApiUrl      string
+	BatchWorkers      int
+	FilterOperators   []string
+	TextSearch        bool
+	DataDir           string
+	HedgePercentile   float64
+	QueryRetries      int
+	Backend           string
+	IndexMetric       string
+	EmbeddingModel    string
+	EmbedderUrl       string
+	OutboxMaxAttempts int
+	NotifyDebounce    time.Duration
+	ExpertiseTTL      time.Duration
+	WebhookSecret     string
+	HealthInterval    time.Duration
+	BreakerThreshold  int
+	GrpcListen        string
+	ApiListen         stringList
+	ApiTLSCert        string
+	ApiTLSKey         string
+	ApiTokens         []apiToken
+	QueryLogRetention time.Duration
+	ConnLowWater      int
+	ConnHighWater     int
+	ConnGracePeriod   time.Duration
+	Partners          addrList
 }
 
 func ParseFlags() (Config, error) {
@@ -54,14 +94,84 @@ func ParseFlags() (Config, error) {
 		"Unique string to identify group of nodes. Share this with your friends to let them connect with you")
 	flag.Var(&config.BootstrapPeers, "peer", "Adds a peer multiaddress to the bootstrap list")
 	flag.Var(&config.ListenAddresses, "listen", "Adds a multiaddress to the listen list")
-	flag.StringVar(&config.ProtocolID, "pid", "/p2p-rag/0.0.0", "Sets a protocol id for stream headers")
+	// The chat stream this set the protocol of is gone; kept so existing
+	// deployments passing it still start
+	protocolId := flag.String("pid", "", "Deprecated: ignored, direct messages use a fixed protocol")
 	flag.StringVar(&config.PrivateKey, "key", "", "Private key in base64 format")
 	flag.StringVar(&config.ClientApiUrl, "client-api-url", "", "Client API URL")
+	flag.IntVar(&config.BatchWorkers, "batch-workers", 4, "Number of batch query items processed concurrently")
+	filterOperators := flag.String("filter-operators", "$eq", "Comma separated metadata filter operators supported by the client API")
+	flag.BoolVar(&config.TextSearch, "text-search", false, "Advertise that the client API supports hybrid text search")
+	flag.StringVar(&config.DataDir, "data-dir", "data", "Directory for persistent node state")
+	flag.Float64Var(&config.HedgePercentile, "hedge-percentile", 95, "Latency percentile after which a query is also sent to the next best peer")
+	flag.IntVar(&config.QueryRetries, "query-retries", 2, "Retries on transient dial errors when querying a peer")
+	flag.StringVar(&config.Backend, "backend", "http", "Search backend answering queries: http (client API) or memory (built-in index)")
+	flag.StringVar(&config.IndexMetric, "index-metric", metricCosine, "Similarity metric of the memory index: cosine or dot")
+	flag.StringVar(&config.EmbeddingModel, "embedding-model", "nomic-embed-text", "Embedding model of the vectors this node computes or stores")
+	flag.StringVar(&config.EmbedderUrl, "embedder-url", "", "Ollama compatible server used to embed text, e.g. http://ollama:11434")
+	flag.IntVar(&config.OutboxMaxAttempts, "outbox-max-attempts", 10, "Delivery attempts before a client API notification is dead-lettered")
+	flag.DurationVar(&config.NotifyDebounce, "notify-debounce", 2*time.Second, "Window in which expertise changes are batched before notifying the client API")
+	flag.DurationVar(&config.ExpertiseTTL, "expertise-ttl", time.Minute, "Time after which an embedding a peer stopped announcing counts as removed")
+	flag.StringVar(&config.WebhookSecret, "webhook-secret", "", "Secret shared with the client API to sign requests sent to it")
+	flag.DurationVar(&config.HealthInterval, "health-interval", 10*time.Second, "Interval between health probes of the search backend")
+	flag.IntVar(&config.BreakerThreshold, "breaker-threshold", 3, "Consecutive search backend failures after which queries fail fast")
+	flag.StringVar(&config.GrpcListen, "grpc-listen", "127.0.0.1:9888", "Address of the gRPC control API, empty to disable it")
+	flag.Var(&config.ApiListen, "api-listen", "Adds an address for the HTTP API, host:port or unix:/path/to/socket (default 127.0.0.1:8888)")
+	flag.StringVar(&config.ApiTLSCert, "api-tls-cert", "", "Certificate file to serve the HTTP and gRPC APIs over TLS")
+	flag.StringVar(&config.ApiTLSKey, "api-tls-key", "", "Private key file of -api-tls-cert")
+	var apiTokenValues stringList
+	flag.Var(&apiTokenValues, "api-token", "Adds an API token as read:<token> or admin:<token>; without tokens the APIs are open")
+	flag.DurationVar(&config.QueryLogRetention, "query-log-retention", 7*24*time.Hour, "How long entries of the query audit log are kept, 0 keeps them forever")
+	flag.IntVar(&config.ConnLowWater, "conn-low", 100, "Connections the connection manager trims down to")
+	flag.IntVar(&config.ConnHighWater, "conn-high", 400, "Connections above which the connection manager starts trimming")
+	flag.DurationVar(&config.ConnGracePeriod, "conn-grace", time.Minute, "Time new connections are exempt from trimming")
+	flag.Var(&config.Partners, "partner", "Adds a partner multiaddress ending in /p2p/<peer ID>, always connected and never trimmed")
 	flag.Parse()
 
+	if *protocolId != "" {
+		logger.Warn("❌ -pid is deprecated and ignored, direct messages use ", messageProtocolID)
+	}
+
+	operators, err := parseFilterOperators(*filterOperators)
+	if err != nil {
+		return config, err
+	}
+	config.FilterOperators = operators
+
+	if config.ExpertiseTTL <= 0 {
+		return config, fmt.Errorf("-expertise-ttl must be positive")
+	}
+	if config.QueryLogRetention < 0 {
+		return config, fmt.Errorf("-query-log-retention must not be negative")
+	}
+	if config.ConnLowWater < 0 || config.ConnHighWater <= config.ConnLowWater {
+		return config, fmt.Errorf("-conn-high must be greater than -conn-low, which must not be negative")
+	}
+	for _, partner := range config.Partners {
+		if _, err := peer.AddrInfoFromP2pAddr(partner); err != nil {
+			return config, fmt.Errorf("-partner %s must end in /p2p/<peer ID>", partner)
+		}
+	}
+	if len(config.ApiListen) == 0 {
+		config.ApiListen = stringList{"127.0.0.1:8888"}
+	}
+	if (config.ApiTLSCert == "") != (config.ApiTLSKey == "") {
+		return config, fmt.Errorf("-api-tls-cert and -api-tls-key must be set together")
+	}
+	tokens, err := parseApiTokens(apiTokenValues)
+	if err != nil {
+		return config, err
+	}
+	config.ApiTokens = tokens
+	if config.HealthInterval <= 0 {
+		return config, fmt.Errorf("-health-interval must be positive")
+	}
+
 	if len(config.BootstrapPeers) == 0 {
 		config.BootstrapPeers = dht.DefaultBootstrapPeers
 	}
 
+	config.EmbedderUrl = strings.TrimRight(config.EmbedderUrl, "/")
+
 	return config, nil
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// GossipMesh follows which peers gossipsub grafted into our mesh per topic.
+// The pubsub router does not expose its mesh, so it is rebuilt from the
+// GRAFT and PRUNE events of a raw tracer.
+type GossipMesh struct {
+	topics map[string]map[peer.ID]bool
+	mutex  sync.RWMutex
+}
+
+// The mesh of our pubsub router, registered as its tracer in main
+var gossipMesh = NewGossipMesh()
+
+// NewGossipMesh creates an empty mesh
+func NewGossipMesh() *GossipMesh {
+	return &GossipMesh{
+		topics: make(map[string]map[peer.ID]bool),
+	}
+}
+
+// Topics returns the topics whose mesh contains the peer
+func (gm *GossipMesh) Topics(p peer.ID) []string {
+	gm.mutex.RLock()
+	defer gm.mutex.RUnlock()
+
+	topics := []string{}
+	for topic, peers := range gm.topics {
+		if peers[p] {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Graft records that a peer joined our mesh for a topic
+func (gm *GossipMesh) Graft(p peer.ID, topic string) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	if gm.topics[topic] == nil {
+		gm.topics[topic] = make(map[peer.ID]bool)
+	}
+	gm.topics[topic][p] = true
+}
+
+// Prune records that a peer left our mesh for a topic
+func (gm *GossipMesh) Prune(p peer.ID, topic string) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	delete(gm.topics[topic], p)
+}
+
+// RemovePeer forgets a disconnected peer
+func (gm *GossipMesh) RemovePeer(p peer.ID) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	for _, peers := range gm.topics {
+		delete(peers, p)
+	}
+}
+
+// Leave forgets the mesh of a topic we unsubscribed from
+func (gm *GossipMesh) Leave(topic string) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+	delete(gm.topics, topic)
+}
+
+// The remaining tracer events are not needed to follow the mesh
+func (gm *GossipMesh) AddPeer(p peer.ID, proto protocol.ID)             {}
+func (gm *GossipMesh) Join(topic string)                                {}
+func (gm *GossipMesh) ValidateMessage(msg *pubsub.Message)              {}
+func (gm *GossipMesh) DeliverMessage(msg *pubsub.Message)               {}
+func (gm *GossipMesh) RejectMessage(msg *pubsub.Message, reason string) {}
+func (gm *GossipMesh) DuplicateMessage(msg *pubsub.Message)             {}
+func (gm *GossipMesh) ThrottlePeer(p peer.ID)                           {}
+func (gm *GossipMesh) RecvRPC(rpc *pubsub.RPC)                          {}
+func (gm *GossipMesh) SendRPC(rpc *pubsub.RPC, p peer.ID)               {}
+func (gm *GossipMesh) DropRPC(rpc *pubsub.RPC, p peer.ID)               {}
+func (gm *GossipMesh) UndeliverableMessage(msg *pubsub.Message)         {}
@@ -0,0 +1,221 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative controlpb/control.proto
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"p2p-rag/controlpb"
+)
+
+// controlServer implements the gRPC control API on top of the operations
+// shared with the HTTP API
+type controlServer struct {
+	controlpb.UnimplementedControlServer
+}
+
+// startGrpcApi serves the control API on -grpc-listen until the process
+// exits, with the same tokens and TLS certificate as the HTTP API
+func startGrpcApi(config Config) {
+	options, err := grpcAuthOptions(config.ApiTLSCert, config.ApiTLSKey)
+	if err != nil {
+		logger.Warn("❌ Failed to load gRPC API certificate:", err)
+		return
+	}
+	listener, err := net.Listen("tcp", config.GrpcListen)
+	if err != nil {
+		logger.Warn("❌ Failed to start gRPC API:", err)
+		return
+	}
+
+	server := grpc.NewServer(options...)
+	controlpb.RegisterControlServer(server, &controlServer{})
+	logger.Info("🛰️ gRPC API listening on ", listener.Addr())
+	if err := server.Serve(listener); err != nil {
+		logger.Warn("❌ gRPC API stopped:", err)
+	}
+}
+
+// grpcError maps an error of a shared operation to a gRPC status
+func grpcError(err error) error {
+	apiErr := asApiError(err)
+	code := codes.Internal
+	switch apiErr.Status {
+	case 400:
+		code = codes.InvalidArgument
+	case 404:
+		code = codes.NotFound
+	case 409:
+		code = codes.FailedPrecondition
+	case 502, 503:
+		code = codes.Unavailable
+	}
+	return status.Error(code, apiErr.Error())
+}
+
+func toProtoEmbeddings(embeddings []Embedding) []*controlpb.Embedding {
+	result := make([]*controlpb.Embedding, len(embeddings))
+	for i, embedding := range embeddings {
+		result[i] = &controlpb.Embedding{
+			Key:       embedding.Key,
+			Expertise: embedding.Expertise,
+			Model:     embedding.Model,
+			Vector:    embedding.Vector,
+		}
+	}
+	return result
+}
+
+// ListExpertise returns the expertise this node announces
+func (s *controlServer) ListExpertise(ctx context.Context, req *controlpb.ListExpertiseRequest) (*controlpb.ListExpertiseResponse, error) {
+	topics := listExpertise()
+	response := &controlpb.ListExpertiseResponse{Topics: make([]*controlpb.Expertise, len(topics))}
+	for i, topic := range topics {
+		response.Topics[i] = &controlpb.Expertise{Embeddings: toProtoEmbeddings(topic.Embeddings)}
+	}
+	return response, nil
+}
+
+// AnnounceExpertise stores and gossips new expertise
+func (s *controlServer) AnnounceExpertise(ctx context.Context, req *controlpb.AnnounceExpertiseRequest) (*controlpb.AnnounceExpertiseResponse, error) {
+	inputs := make([]EmbeddingInput, len(req.GetEmbeddings()))
+	for i, embedding := range req.GetEmbeddings() {
+		inputs[i] = EmbeddingInput{
+			Key:       embedding.GetKey(),
+			Model:     embedding.GetModel(),
+			Expertise: embedding.GetExpertise(),
+			Vector:    embedding.GetVector(),
+		}
+	}
+
+	expertiseData, err := announceExpertise(ctx, inputs)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &controlpb.AnnounceExpertiseResponse{EmbeddingCount: int32(len(expertiseData.Embeddings))}, nil
+}
+
+// Query queries this node or a peer
+func (s *controlServer) Query(ctx context.Context, req *controlpb.QueryRequest) (*controlpb.QueryResponse, error) {
+	input := QueryInput{
+		PeerId:   req.GetNodeId(),
+		QueryId:  req.GetQueryId(),
+		Question: req.GetQuestion(),
+		Embedding: EmbeddingQuery{
+			ExpertiseKey: req.GetExpertiseKey(),
+			Model:        req.GetModel(),
+			Vector:       req.GetVector(),
+			MatchCount:   int(req.GetMatchCount()),
+			Filter:       QueryFilter(req.GetFilter().AsMap()),
+			QueryText:    req.GetQueryText(),
+		},
+		Hedge: req.Hedge,
+	}
+	if req.Retries != nil {
+		retries := int(req.GetRetries())
+		input.Retries = &retries
+	}
+	if len(input.Embedding.Filter) == 0 {
+		input.Embedding.Filter = nil
+	}
+
+	request, response, err := runQuery(ctx, input)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resultJson, err := json.Marshal(response.Result)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	provenance := provenanceHeaders(request, *response)
+	return &controlpb.QueryResponse{
+		ResultJson: resultJson,
+		Signer:     response.Signer,
+		Signature:  response.Signature,
+		ResultHash: provenance[resultHashHeader],
+	}, nil
+}
+
+// ListPeers returns the peers we are connected to or know the expertise of
+func (s *controlServer) ListPeers(ctx context.Context, req *controlpb.ListPeersRequest) (*controlpb.ListPeersResponse, error) {
+	peers := peerManager.Peers(globalHost)
+	response := &controlpb.ListPeersResponse{Peers: make([]*controlpb.Peer, len(peers))}
+	for i, info := range peers {
+		response.Peers[i] = toProtoPeer(info)
+	}
+	return response, nil
+}
+
+// GetPeer describes a single peer
+func (s *controlServer) GetPeer(ctx context.Context, req *controlpb.GetPeerRequest) (*controlpb.Peer, error) {
+	info, err := describePeer(req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoPeer(info), nil
+}
+
+func toProtoPeer(info PeerInfo) *controlpb.Peer {
+	result := &controlpb.Peer{
+		Id:            info.Id,
+		Addresses:     info.Addresses,
+		Connected:     info.Connected,
+		Reputation:    info.Reputation,
+		Available:     info.Available,
+		ExpertiseKeys: info.ExpertiseKeys,
+		Direction:     info.Direction,
+		Protocols:     info.Protocols,
+		AgentVersion:  info.AgentVersion,
+		LatencyMs:     info.LatencyMs,
+		MeshTopics:    info.MeshTopics,
+		Relevance:     int32(info.Relevance),
+	}
+	if !info.LastSeen.IsZero() {
+		result.LastSeen = timestamppb.New(info.LastSeen)
+	}
+	if !info.ConnectedSince.IsZero() {
+		result.ConnectedSince = timestamppb.New(info.ConnectedSince)
+	}
+	if !info.FirstSeen.IsZero() {
+		result.FirstSeen = timestamppb.New(info.FirstSeen)
+	}
+	return result
+}
+
+// StreamEvents sends node events until the client goes away
+func (s *controlServer) StreamEvents(req *controlpb.StreamEventsRequest, stream grpc.ServerStreamingServer[controlpb.Event]) error {
+	types, err := parseEventTypes(req.GetTypes())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	ch, unsubscribe := events.Subscribe(types)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-ch:
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.Send(&controlpb.Event{
+				Type:     event.Type,
+				Time:     timestamppb.New(event.Time),
+				NodeId:   event.PeerId,
+				DataJson: data,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{err: newApiError(400, "Invalid peer ID", nil), code: codes.InvalidArgument},
+		{err: newApiError(404, "Peer not found", nil), code: codes.NotFound},
+		{err: newApiError(409, "Peer is banned", nil), code: codes.FailedPrecondition},
+		{err: newApiError(500, "Failed to query self", nil), code: codes.Internal},
+		{err: newApiError(502, "Failed to connect to peer", nil), code: codes.Unavailable},
+		{err: newApiError(503, "Search backend unavailable", nil), code: codes.Unavailable},
+		{err: errors.New("unexpected"), code: codes.Internal},
+	}
+	for _, test := range tests {
+		if got := status.Code(grpcError(test.err)); got != test.code {
+			t.Errorf("grpcError(%q) code = %s, want %s", test.err, got, test.code)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+)
+
+// Number of recent query latencies kept for the hedge delay
+const latencySampleSize = 200
+
+// Below this many samples the default hedge delay is used
+const minHedgeSamples = 10
+
+// Bounds of the delay after which a hedged request is sent
+const (
+	defaultHedgeDelay = 2 * time.Second
+	minHedgeDelay     = 50 * time.Millisecond
+)
+
+// First wait between retries of a failed dial; doubled on each retry
+const retryBackoff = 100 * time.Millisecond
+
+// Latency percentile after which queries are hedged
+var hedgePercentile = 95.0
+
+// Default number of retries on transient dial errors
+var queryRetries = 2
+
+// errOpenStream marks failures to open a stream to a peer
+var errOpenStream = errors.New("failed to open stream to peer")
+
+// errPeerUnreachable marks failures to reach a peer that are worth retrying
+// after dialing it again
+var errPeerUnreachable = errors.New("peer is unreachable")
+
+// isTransientDialError tells whether err is a failed or timed out dial, as
+// opposed to e.g. a peer that does not support the protocol
+func isTransientDialError(err error) bool {
+	var dialErr *swarm.DialError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dialErr),
+		errors.Is(err, swarm.ErrDialBackoff),
+		errors.Is(err, network.ErrNoConn),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, os.ErrDeadlineExceeded):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// HedgePolicy controls hedging and retries for a single query
+type HedgePolicy struct {
+	Hedge   bool
+	Retries int
+}
+
+// latencyTracker keeps a window of recent successful query latencies
+type latencyTracker struct {
+	samples []time.Duration
+	next    int
+	mutex   sync.Mutex
+}
+
+var queryLatencies = &latencyTracker{}
+
+// Observe adds a latency sample, replacing the oldest one when full
+func (lt *latencyTracker) Observe(latency time.Duration) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	if len(lt.samples) < latencySampleSize {
+		lt.samples = append(lt.samples, latency)
+		return
+	}
+	lt.samples[lt.next] = latency
+	lt.next = (lt.next + 1) % latencySampleSize
+}
+
+// Percentile returns the latency below which p percent of the samples fall
+func (lt *latencyTracker) Percentile(p float64) (time.Duration, bool) {
+	lt.mutex.Lock()
+	sorted := append([]time.Duration(nil), lt.samples...)
+	lt.mutex.Unlock()
+
+	if len(sorted) < minHedgeSamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(p / 100 * float64(len(sorted)-1))
+	return sorted[min(max(index, 0), len(sorted)-1)], true
+}
+
+// hedgeDelay returns how long to wait for the primary peer before hedging
+func hedgeDelay() time.Duration {
+	delay, ok := queryLatencies.Percentile(hedgePercentile)
+	if !ok {
+		return defaultHedgeDelay
+	}
+	return max(delay, minHedgeDelay)
+}
+
+// nextBestPeer returns the best matching connected peer other than the excluded ones
+func nextBestPeer(host host.Host, vector Vector, exclude ...string) string {
+	for _, match := range knownExpertise.Match(vector) {
+		id := match.Peer.String()
+		if id == host.ID().String() || slices.Contains(exclude, id) {
+			continue
+		}
+		if host.Network().Connectedness(match.Peer) == network.Connected {
+			return id
+		}
+	}
+	return ""
+}
+
+// queryRemotePeerWithRetry retries queries that could not reach the peer
+// with exponential backoff, dialing the peer again before each retry
+func queryRemotePeerWithRetry(ctx context.Context, host host.Host, peerIdStr string, request QueryRequest, retries int) (*QueryResponse, error) {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		response, err := queryRemotePeer(ctx, host, peerIdStr, request)
+		if err == nil || !errors.Is(err, errPeerUnreachable) || attempt >= retries {
+			return response, err
+		}
+
+		logger.Warn("🔁 Retrying query to peer ", peerIdStr, " in ", backoff, ": ", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+
+		// Dial the peer at its known addresses; a failure shows on the next attempt
+		if peerID, err := peer.Decode(peerIdStr); err == nil {
+			if err := host.Connect(ctx, peer.AddrInfo{ID: peerID}); err != nil {
+				logger.Warn("❌ Failed to redial peer ", peerIdStr, ":", err)
+			}
+		}
+	}
+}
+
+// queryWithHedging queries the primary peer. If it is slower than the hedge
+// delay, or fails first, the same request goes to the next best matching
+// peer. The first successful answer wins and the other request is cancelled.
+func queryWithHedging(ctx context.Context, host host.Host, primary string, request QueryRequest, policy HedgePolicy) (*QueryResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		response *QueryResponse
+		err      error
+	}
+	// Buffered for both attempts so a losing goroutine never blocks
+	results := make(chan attempt, 2)
+	launch := func(peerIdStr string) {
+		go func() {
+			response, err := queryRemotePeerWithRetry(ctx, host, peerIdStr, request, policy.Retries)
+			results <- attempt{response: response, err: err}
+		}()
+	}
+
+	launch(primary)
+	pending := 1
+
+	var backup string
+	var hedgeTimer <-chan time.Time
+	if policy.Hedge {
+		if backup = nextBestPeer(host, request.Vector, primary); backup != "" {
+			timer := time.NewTimer(hedgeDelay())
+			defer timer.Stop()
+			hedgeTimer = timer.C
+		}
+	}
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			logger.Info("⏱️ Peer ", primary, " is slow, hedging query to ", backup)
+			launch(backup)
+			pending++
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				return result.response, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			// The primary failed before the hedge fired, fail over right away
+			if hedgeTimer != nil {
+				hedgeTimer = nil
+				logger.Info("↪️ Failing over query to ", backup)
+				launch(backup)
+				pending++
+			}
+		}
+	}
+	return nil, firstErr
+}
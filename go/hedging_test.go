@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+)
+
+// useReputation records query outcomes in a fresh store for the rest of the test
+func useReputation(t *testing.T) {
+	store, err := NewReputationStore(filepath.Join(t.TempDir(), "reputation.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := reputation
+	reputation = store
+	t.Cleanup(func() { reputation = previous })
+}
+
+func TestIsTransientDialError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("failed to dial: %w", swarm.ErrDialBackoff), true},
+		{&swarm.DialError{}, true},
+		{context.DeadlineExceeded, true},
+		{network.ErrNoConn, true},
+		{errors.New("protocols not supported: [/p2p-rag/query/0.0.1]"), false},
+		{errors.New("stream reset"), false},
+	}
+	for _, test := range tests {
+		if got := isTransientDialError(test.err); got != test.want {
+			t.Errorf("isTransientDialError(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestQueryRemotePeerWithRetryRedials(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupQueryProtocol(local)
+	useQueryAudit(t)
+	useReputation(t)
+	useSearchBackend(t, &fakeSearchBackend{result: memoryResult("a")})
+
+	// Drop the connection; the retry has to dial the peer again
+	if err := remote.Network().ClosePeer(local.ID()); err != nil {
+		t.Fatal(err)
+	}
+	remote.Peerstore().AddAddrs(local.ID(), local.Addrs(), peerstore.PermanentAddrTTL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := queryRemotePeerWithRetry(ctx, remote, local.ID().String(), QueryRequest{QueryId: "q1"}, 1)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if !response.Success {
+		t.Errorf("unexpected response %+v", response)
+	}
+}
+
+func TestQueryRemotePeerWithRetrySkipsUnsupportedProtocol(t *testing.T) {
+	remote, local := newTestHosts(t)
+	audit := useQueryAudit(t)
+	useReputation(t)
+
+	// local does not serve the query protocol, retrying cannot help
+	_, err := queryRemotePeerWithRetry(context.Background(), remote, local.ID().String(), QueryRequest{QueryId: "q1"}, 2)
+	if err == nil {
+		t.Fatal("query to a peer without the protocol succeeded")
+	}
+	if errors.Is(err, errPeerUnreachable) {
+		t.Errorf("unsupported protocol treated as unreachable: %v", err)
+	}
+	if entries := auditEntries(t, audit); len(entries) != 1 {
+		t.Errorf("got %d attempts, want 1", len(entries))
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often a changed index is snapshotted to disk
+const indexSnapshotInterval = time.Minute
+
+// Share of the score taken from keyword matches when a query carries text
+const textSearchWeight = 0.3
+
+// Number of results returned when a query does not set match_count
+const defaultMatchCount = 10
+
+// Similarity metrics supported by the memory index
+const (
+	metricCosine = "cosine"
+	metricDot    = "dot"
+)
+
+// Document is a chunk of content stored in the memory index
+type Document struct {
+	Id        string                 `json:"id"`
+	Title     string                 `json:"title"`
+	Summary   string                 `json:"summary"`
+	Content   string                 `json:"content"`
+	Url       string                 `json:"url"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	Embedding []float32              `json:"embedding"`
+}
+
+// SearchHit is a document matching a query together with its score
+type SearchHit struct {
+	Document   Document
+	Similarity float32
+}
+
+// MemoryIndex is a brute force vector index. Embeddings are kept in one
+// contiguous float32 slice so scoring runs over linear memory.
+type MemoryIndex struct {
+	dimension int
+	metric    string
+	docs      []Document
+	vectors   []float32
+	norms     []float32
+	positions map[string]int
+	dirty     bool
+	mutex     sync.RWMutex
+}
+
+// NewMemoryIndex creates an empty index for vectors of the given dimension
+func NewMemoryIndex(dimension int, metric string) (*MemoryIndex, error) {
+	if metric != metricCosine && metric != metricDot {
+		return nil, fmt.Errorf("unknown index metric %q", metric)
+	}
+	return &MemoryIndex{
+		dimension: dimension,
+		metric:    metric,
+		positions: make(map[string]int),
+	}, nil
+}
+
+// Len returns the number of documents in the index
+func (mi *MemoryIndex) Len() int {
+	mi.mutex.RLock()
+	defer mi.mutex.RUnlock()
+	return len(mi.docs)
+}
+
+// Upsert adds a document or replaces the document with the same ID
+func (mi *MemoryIndex) Upsert(doc Document) error {
+	if doc.Id == "" {
+		return fmt.Errorf("document ID must not be empty")
+	}
+	if len(doc.Embedding) != mi.dimension {
+		return fmt.Errorf("embedding must have exactly %d values", mi.dimension)
+	}
+
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	norm := vectorNorm(doc.Embedding)
+	if i, ok := mi.positions[doc.Id]; ok {
+		mi.docs[i] = doc
+		copy(mi.vectors[i*mi.dimension:], doc.Embedding)
+		mi.norms[i] = norm
+	} else {
+		mi.positions[doc.Id] = len(mi.docs)
+		mi.docs = append(mi.docs, doc)
+		mi.vectors = append(mi.vectors, doc.Embedding...)
+		mi.norms = append(mi.norms, norm)
+	}
+	mi.dirty = true
+	return nil
+}
+
+// Delete removes a document and reports whether it existed
+func (mi *MemoryIndex) Delete(id string) bool {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	i, ok := mi.positions[id]
+	if !ok {
+		return false
+	}
+
+	// Move the last document into the freed slot to keep storage contiguous
+	last := len(mi.docs) - 1
+	if i != last {
+		mi.docs[i] = mi.docs[last]
+		copy(mi.vectors[i*mi.dimension:(i+1)*mi.dimension], mi.vectors[last*mi.dimension:])
+		mi.norms[i] = mi.norms[last]
+		mi.positions[mi.docs[i].Id] = i
+	}
+	mi.docs = mi.docs[:last]
+	mi.vectors = mi.vectors[:last*mi.dimension]
+	mi.norms = mi.norms[:last]
+	delete(mi.positions, id)
+	mi.dirty = true
+	return true
+}
+
+// Documents returns a copy of all stored documents
+func (mi *MemoryIndex) Documents() []Document {
+	mi.mutex.RLock()
+	defer mi.mutex.RUnlock()
+	return append([]Document(nil), mi.docs...)
+}
+
+// Search returns the k best documents matching the filter. If text is set,
+// keyword matches are blended into the vector score.
+func (mi *MemoryIndex) Search(query []float32, k int, filter QueryFilter, text string) []SearchHit {
+	mi.mutex.RLock()
+	defer mi.mutex.RUnlock()
+
+	if len(query) != mi.dimension {
+		return nil
+	}
+	queryNorm := vectorNorm(query)
+	terms := strings.Fields(strings.ToLower(text))
+
+	hits := make([]SearchHit, 0, min(k, len(mi.docs)))
+	for i, doc := range mi.docs {
+		if !filter.Matches(doc.Metadata) {
+			continue
+		}
+
+		score := dotProduct(query, mi.vectors[i*mi.dimension:(i+1)*mi.dimension])
+		if mi.metric == metricCosine {
+			if queryNorm == 0 || mi.norms[i] == 0 {
+				score = 0
+			} else {
+				score /= queryNorm * mi.norms[i]
+			}
+		}
+		if len(terms) > 0 {
+			score = (1-textSearchWeight)*score + textSearchWeight*keywordScore(terms, doc)
+		}
+		hits = append(hits, SearchHit{Document: doc, Similarity: score})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Similarity > hits[j].Similarity
+	})
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits
+}
+
+// keywordScore is the share of query terms found in the document
+func keywordScore(terms []string, doc Document) float32 {
+	haystack := strings.ToLower(doc.Title + " " + doc.Content)
+	found := 0
+	for _, term := range terms {
+		if strings.Contains(haystack, term) {
+			found++
+		}
+	}
+	return float32(found) / float32(len(terms))
+}
+
+// dotProduct is unrolled by four so the compiler can keep the loop in registers
+func dotProduct(a, b []float32) float32 {
+	var s0, s1, s2, s3 float32
+	n := len(a) &^ 3
+	for i := 0; i < n; i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for i := n; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+func vectorNorm(v []float32) float32 {
+	return float32(math.Sqrt(float64(dotProduct(v, v))))
+}
+
+// toFloat32 converts a query vector to the index's storage format
+func toFloat32(vector []float64) []float32 {
+	converted := make([]float32, len(vector))
+	for i, v := range vector {
+		converted[i] = float32(v)
+	}
+	return converted
+}
+
+// indexSnapshot is the on-disk format of the memory index
+type indexSnapshot struct {
+	Dimension int
+	Documents []Document
+}
+
+func init() {
+	// Metadata holds decoded JSON, whose nested types gob must know about
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// SaveSnapshot writes the index to path if it changed since the last snapshot
+func (mi *MemoryIndex) SaveSnapshot(path string) error {
+	mi.mutex.Lock()
+	defer mi.mutex.Unlock()
+
+	if !mi.dirty {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(indexSnapshot{Dimension: mi.dimension, Documents: mi.docs}); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return err
+	}
+	mi.dirty = false
+	return nil
+}
+
+// LoadSnapshot fills the index from a snapshot, if one exists at path
+func (mi *MemoryIndex) LoadSnapshot(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var snapshot indexSnapshot
+	if err := gob.NewDecoder(file).Decode(&snapshot); err != nil {
+		return fmt.Errorf("failed to read index snapshot: %w", err)
+	}
+	if snapshot.Dimension != mi.dimension {
+		return fmt.Errorf("index snapshot has dimension %d, expected %d", snapshot.Dimension, mi.dimension)
+	}
+
+	for _, doc := range snapshot.Documents {
+		if err := mi.Upsert(doc); err != nil {
+			return err
+		}
+	}
+
+	mi.mutex.Lock()
+	mi.dirty = false
+	mi.mutex.Unlock()
+	return nil
+}
+
+// snapshotIndexPeriodically persists the memory index in the background
+func snapshotIndexPeriodically(mi *MemoryIndex, path string) {
+	for {
+		time.Sleep(indexSnapshotInterval)
+		if err := mi.SaveSnapshot(path); err != nil {
+			logger.Warn("❌ Error saving index snapshot:", err)
+		}
+	}
+}
+
+// MemorySearchBackend answers queries from the built-in memory index
+type MemorySearchBackend struct {
+	index *MemoryIndex
+}
+
+// NewMemorySearchBackend creates a backend on top of a memory index
+func NewMemorySearchBackend(index *MemoryIndex) *MemorySearchBackend {
+	return &MemorySearchBackend{index: index}
+}
+
+// Query searches the memory index and returns the results in the same shape
+// as the client API does
+func (b *MemorySearchBackend) Query(ctx context.Context, request QueryRequest) (interface{}, error) {
+	matchCount := request.MatchCount
+	if matchCount <= 0 {
+		matchCount = defaultMatchCount
+	}
+
+	hits := b.index.Search(toFloat32(request.Vector[:]), matchCount, request.Filter, request.QueryText)
+
+	documents := make([]map[string]interface{}, len(hits))
+	for i, hit := range hits {
+		documents[i] = map[string]interface{}{
+			"id":         hit.Document.Id,
+			"title":      hit.Document.Title,
+			"summary":    hit.Document.Summary,
+			"content":    hit.Document.Content,
+			"source":     hit.Document.Url,
+			"metadata":   hit.Document.Metadata,
+			"similarity": float64(hit.Similarity),
+		}
+	}
+
+	return map[string]interface{}{
+		"query": map[string]interface{}{
+			"queryId": request.QueryId,
+			"model":   request.Model,
+		},
+		"answer": map[string]interface{}{
+			"documents": documents,
+		},
+	}, nil
+}
+
+// Health always succeeds since the index lives in the node itself
+func (b *MemorySearchBackend) Health(ctx context.Context) error {
+	return nil
+}
+
+// Capabilities reports that the memory index evaluates every filter operator
+// and supports keyword search
+func (b *MemorySearchBackend) Capabilities() QueryCapabilities {
+	return QueryCapabilities{
+		FilterOperators: knownFilterOperators,
+		TextSearch:      true,
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Protocol ID for direct messages between nodes
+const messageProtocolID = "/p2p-rag/msg/0.0.1"
+
+// Largest message body, in bytes
+const maxMessageSize = 64 << 10
+
+// Messages kept in the inbox; the oldest are dropped first
+const inboxCapacity = 1000
+
+// Messages kept from a single sender; further messages are refused until
+// some of them are deleted
+const inboxSenderCapacity = 100
+
+// Records the inbox log may hold before it is rewritten with the current
+// messages only
+const inboxCompactThreshold = 2 * inboxCapacity
+
+// How long a message may take to be delivered to the peer
+const messageSendTimeout = 10 * time.Second
+
+// Message is a direct message sent to or received from a peer. From and To
+// are set by the receiving node from the connection, never trusted from
+// the sender.
+type Message struct {
+	Id         string    `json:"id"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Body       string    `json:"body"`
+	SentAt     time.Time `json:"sent_at"`
+	ReceivedAt time.Time `json:"received_at,omitzero"`
+}
+
+// messageAck is the receiver's answer to a message
+type messageAck struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// validateMessageBody checks a body before it is sent or stored
+func validateMessageBody(body string) error {
+	if body == "" {
+		return fmt.Errorf("message body must not be empty")
+	}
+	if len(body) > maxMessageSize {
+		return fmt.Errorf("message body must be at most %d bytes", maxMessageSize)
+	}
+	return nil
+}
+
+// errSenderInboxFull refuses messages from a sender holding too many
+var errSenderInboxFull = errors.New("inbox is full for this sender")
+
+// Inbox keeps the messages received from peers. Changes are appended to a
+// JSON lines log, which is compacted once it holds many stale records.
+type Inbox struct {
+	messages []Message
+	path     string
+	records  int
+	mutex    sync.Mutex
+}
+
+// inboxRecord is one line of the inbox log: a stored or a deleted message
+type inboxRecord struct {
+	Message *Message   `json:"message,omitempty"`
+	Deleted *messageId `json:"deleted,omitempty"`
+}
+
+// messageId identifies a message; IDs are chosen by senders, so they are
+// only unique per sender
+type messageId struct {
+	From string `json:"from"`
+	Id   string `json:"id"`
+}
+
+// The messages received from peers, loaded in main
+var inbox = &Inbox{}
+
+// NewInbox loads the inbox log stored at path, if any
+func NewInbox(path string) (*Inbox, error) {
+	in := &Inbox{path: path}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return in, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var record inboxRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			// A crash while appending leaves a truncated last record
+			logger.Warn("❌ Ignoring the rest of the inbox log:", err)
+			break
+		}
+		in.records++
+		switch {
+		case record.Message != nil:
+			in.insert(*record.Message)
+		case record.Deleted != nil:
+			in.remove(*record.Deleted)
+		}
+	}
+	return in, nil
+}
+
+func (in *Inbox) indexOf(id messageId) int {
+	return slices.IndexFunc(in.messages, func(m Message) bool {
+		return m.Id == id.Id && m.From == id.From
+	})
+}
+
+// insert adds a message, dropping the oldest beyond the capacity
+func (in *Inbox) insert(message Message) {
+	in.messages = append(in.messages, message)
+	if len(in.messages) > inboxCapacity {
+		in.messages = slices.Clone(in.messages[len(in.messages)-inboxCapacity:])
+	}
+}
+
+func (in *Inbox) remove(id messageId) bool {
+	i := in.indexOf(id)
+	if i < 0 {
+		return false
+	}
+	in.messages = slices.Delete(in.messages, i, i+1)
+	return true
+}
+
+// persist appends a record to the log, or rewrites the log with the current
+// messages once it holds too many records; callers must hold the mutex
+func (in *Inbox) persist(record inboxRecord) {
+	if in.path == "" {
+		return
+	}
+	if in.records >= inboxCompactThreshold {
+		in.compact()
+		return
+	}
+
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = appendFile(in.path, append(data, '\n'))
+	}
+	if err != nil {
+		logger.Warn("❌ Error saving inbox:", err)
+		return
+	}
+	in.records++
+}
+
+// compact rewrites the log with one record per message; callers must hold
+// the mutex
+func (in *Inbox) compact() {
+	var data []byte
+	for _, message := range in.messages {
+		line, err := json.Marshal(inboxRecord{Message: &message})
+		if err != nil {
+			logger.Warn("❌ Error encoding inbox:", err)
+			return
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := writeFileAtomic(in.path, data); err != nil {
+		logger.Warn("❌ Error saving inbox:", err)
+		return
+	}
+	in.records = len(in.messages)
+}
+
+// Add stores a message, returning false when the sender already delivered
+// a message with the same ID and errSenderInboxFull when the sender already
+// has inboxSenderCapacity messages stored
+func (in *Inbox) Add(message Message) (bool, error) {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	if in.indexOf(messageId{From: message.From, Id: message.Id}) >= 0 {
+		return false, nil
+	}
+	stored := 0
+	for _, m := range in.messages {
+		if m.From == message.From {
+			stored++
+		}
+	}
+	if stored >= inboxSenderCapacity {
+		return false, errSenderInboxFull
+	}
+
+	in.insert(message)
+	in.persist(inboxRecord{Message: &message})
+	return true, nil
+}
+
+// List returns a page of the messages from a peer (all peers when empty)
+// received after since, newest first, and how many messages match in total
+func (in *Inbox) List(from string, since time.Time, offset int, limit int) ([]Message, int) {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	matching := []Message{}
+	for _, message := range slices.Backward(in.messages) {
+		if (from == "" || message.From == from) && message.ReceivedAt.After(since) {
+			matching = append(matching, message)
+		}
+	}
+	total := len(matching)
+	if offset >= total {
+		return []Message{}, total
+	}
+	return matching[offset:min(offset+limit, total)], total
+}
+
+// Delete removes the message a sender sent with an ID, returning whether
+// it existed
+func (in *Inbox) Delete(from string, id string) bool {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	key := messageId{From: from, Id: id}
+	if !in.remove(key) {
+		return false
+	}
+	in.persist(inboxRecord{Deleted: &key})
+	return true
+}
+
+// setupMessageProtocol initializes the direct message protocol handler
+func setupMessageProtocol(host host.Host) {
+	host.SetStreamHandler(protocol.ID(messageProtocolID), handleMessageStream)
+}
+
+// handleMessageStream stores a message from a peer in the inbox, publishes
+// it as an event and pushes it to the client API
+func handleMessageStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(messageSendTimeout))
+
+	reply := func(ack messageAck) {
+		if err := json.NewEncoder(stream).Encode(ack); err != nil {
+			logger.Warn("❌ Error sending message acknowledgement:", err)
+		}
+	}
+
+	// The body is JSON encoded, escaping may make it up to six times larger
+	var message Message
+	if err := json.NewDecoder(io.LimitReader(stream, 6*maxMessageSize+1024)).Decode(&message); err != nil {
+		logger.Warn("❌ Error decoding message:", err)
+		reply(messageAck{Error: "Failed to decode message"})
+		return
+	}
+	if message.Id == "" {
+		reply(messageAck{Error: "message id must not be empty"})
+		return
+	}
+	if err := validateMessageBody(message.Body); err != nil {
+		reply(messageAck{Error: err.Error()})
+		return
+	}
+
+	message.From = stream.Conn().RemotePeer().String()
+	message.To = stream.Conn().LocalPeer().String()
+	message.ReceivedAt = time.Now()
+
+	// A resent message is acknowledged again but delivered only once
+	added, err := inbox.Add(message)
+	if err != nil {
+		logger.Warn("❌ Refusing message from peer ", message.From, ":", err)
+		reply(messageAck{Error: err.Error()})
+		return
+	}
+	if added {
+		logger.Info("✉️ Received message ", message.Id, " from peer: ", message.From)
+		events.Publish(eventMessageReceived, message.From, message)
+		if err := outbox.Enqueue(message.From, "/messages", message); err != nil {
+			logger.Warn("❌ Failed to queue message notification:", err)
+		}
+	}
+	reply(messageAck{Success: true})
+}
+
+// sendMessageToPeer delivers a message to a peer and waits for its
+// acknowledgement
+func sendMessageToPeer(ctx context.Context, host host.Host, peerID peer.ID, message Message) error {
+	ctx, cancel := context.WithTimeout(ctx, messageSendTimeout)
+	defer cancel()
+
+	stream, err := host.NewStream(ctx, peerID, protocol.ID(messageProtocolID))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errOpenStream, err)
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(stream).Encode(message); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	var ack messageAck
+	if err := json.NewDecoder(stream).Decode(&ack); err != nil {
+		return fmt.Errorf("failed to read message acknowledgement: %w", err)
+	}
+	if !ack.Success {
+		return fmt.Errorf("peer rejected message: %s", ack.Error)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Version of the node, set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// When the node started
+var startTime = time.Now()
+
+// Timeout of the client API reachability check
+const clientApiCheckTimeout = 2 * time.Second
+
+// Set in main once the p2p side is up
+var (
+	kademliaDHT      *dht.IpfsDHT
+	rendezvousString string
+	gossipTopicName  = "/rag-topics"
+)
+
+// reachability is the latest reachability reported by AutoNAT
+var reachability = struct {
+	value network.Reachability
+	since time.Time
+	mutex sync.RWMutex
+}{value: network.ReachabilityUnknown}
+
+// trackReachability follows the AutoNAT reachability of the host
+func trackReachability(h host.Host) {
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		logger.Warn("❌ Failed to subscribe to reachability changes:", err)
+		return
+	}
+	go func() {
+		defer sub.Close()
+		for e := range sub.Out() {
+			changed := e.(event.EvtLocalReachabilityChanged)
+			logger.Info("🧭 Reachability is now ", changed.Reachability)
+
+			reachability.mutex.Lock()
+			reachability.value = changed.Reachability
+			reachability.since = time.Now()
+			reachability.mutex.Unlock()
+		}
+	}()
+}
+
+// BuildInfo describes the binary the node runs
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// ClientApiStatus tells whether the client API answers
+type ClientApiStatus struct {
+	Url       string `json:"url"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NodeStatus is the identity and state of this node
+type NodeStatus struct {
+	PeerId            string          `json:"nodeId"`
+	ListenAddresses   []string        `json:"listen_addresses"`
+	Addresses         []string        `json:"addresses"`
+	ObservedAddresses []string        `json:"observed_addresses"`
+	Rendezvous        string          `json:"rendezvous"`
+	Protocols         []string        `json:"protocols"`
+	RoutingTableSize  int             `json:"routing_table_size"`
+	PubsubTopic       string          `json:"pubsub_topic"`
+	TopicPeers        int             `json:"topic_peers"`
+	ConnectedPeers    int             `json:"connected_peers"`
+	Reachability      string          `json:"reachability"`
+	ReachabilitySince time.Time       `json:"reachability_since,omitzero"`
+	Backend           string          `json:"backend"`
+	BackendAvailable  bool            `json:"backend_available"`
+	ClientApi         ClientApiStatus `json:"client_api"`
+	Build             BuildInfo       `json:"build"`
+	StartedAt         time.Time       `json:"started_at"`
+	UptimeSeconds     int64           `json:"uptime_seconds"`
+}
+
+// buildInfo reads the version control details Go embeds in the binary
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: version}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = build.GoVersion
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// checkClientApi tells whether the client API answers its health route
+func checkClientApi(ctx context.Context) ClientApiStatus {
+	status := ClientApiStatus{Url: clientApiUrl}
+	if clientApiUrl == "" {
+		status.Error = "no -client-api-url configured"
+		return status
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, clientApiCheckTimeout)
+	defer cancel()
+	if err := NewHTTPSearchBackend(clientApiUrl, QueryCapabilities{}).Health(ctx); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Reachable = true
+	return status
+}
+
+func multiaddrStrings(addrs []multiaddr.Multiaddr) []string {
+	result := make([]string, len(addrs))
+	for i, addr := range addrs {
+		result[i] = addr.String()
+	}
+	return result
+}
+
+// nodeStatus collects the identity and state of this node
+func nodeStatus(ctx context.Context, backend string) NodeStatus {
+	reachability.mutex.RLock()
+	status := NodeStatus{
+		ListenAddresses:   []string{},
+		Addresses:         []string{},
+		ObservedAddresses: []string{},
+		Protocols:         []string{},
+		Rendezvous:        rendezvousString,
+		PubsubTopic:       gossipTopicName,
+		Reachability:      reachability.value.String(),
+		ReachabilitySince: reachability.since,
+		Backend:           backend,
+		BackendAvailable:  localAvailability(),
+		Build:             buildInfo(),
+		StartedAt:         startTime,
+		UptimeSeconds:     int64(time.Since(startTime).Seconds()),
+	}
+	reachability.mutex.RUnlock()
+	status.ClientApi = checkClientApi(ctx)
+
+	if globalHost == nil {
+		return status
+	}
+	status.PeerId = globalHost.ID().String()
+	status.ListenAddresses = multiaddrStrings(globalHost.Network().ListenAddresses())
+	status.Addresses = multiaddrStrings(globalHost.Addrs())
+	if h, ok := globalHost.(interface{ IDService() identify.IDService }); ok {
+		status.ObservedAddresses = multiaddrStrings(h.IDService().OwnObservedAddrs())
+	}
+	for _, proto := range globalHost.Mux().Protocols() {
+		status.Protocols = append(status.Protocols, string(proto))
+	}
+	status.ConnectedPeers = len(globalHost.Network().Peers())
+	if kademliaDHT != nil {
+		status.RoutingTableSize = kademliaDHT.RoutingTable().Size()
+	}
+	if topic != nil {
+		status.TopicPeers = len(topic.ListPeers())
+	}
+	return status
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bounds of the exponential backoff between delivery attempts
+const (
+	outboxInitialBackoff = time.Second
+	outboxMaxBackoff     = 5 * time.Minute
+)
+
+// Number of delivery attempts before a message is dead-lettered
+var outboxMaxAttempts = 10
+
+// Messages the outbox holds waiting for delivery, and dead-lettered; the
+// oldest dead letters are dropped first
+const (
+	outboxCapacity     = 10000
+	outboxDeadCapacity = 1000
+)
+
+// Records the outbox log holds at least before it is compacted
+const outboxCompactMinRecords = 1000
+
+// errOutboxFull refuses notifications while too many are pending
+var errOutboxFull = errors.New("outbox is full")
+
+// Status of a message in the outbox log
+const (
+	outboxPending   = "pending"
+	outboxDead      = "dead"
+	outboxDelivered = "delivered"
+)
+
+// OutboxMessage is a notification waiting to be delivered to the client API
+type OutboxMessage struct {
+	Id          uint64          `json:"id"`
+	PeerId      string          `json:"nodeId"`
+	Path        string          `json:"path"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// outboxRecord is one line of the outbox log: the latest state of a
+// message. Delivered messages are recorded by ID only.
+type outboxRecord struct {
+	Id      uint64         `json:"id"`
+	Status  string         `json:"status"`
+	Message *OutboxMessage `json:"message,omitempty"`
+}
+
+// Outbox is a persistent queue of client API notifications. Messages are
+// delivered in order per peer; a failing message blocks the messages queued
+// after it for the same peer until it succeeds or is dead-lettered. Every
+// change is appended to a JSON lines log, which is compacted once most of
+// its records are stale.
+type Outbox struct {
+	path    string
+	nextId  uint64
+	pending map[string][]*OutboxMessage
+	dead    []*OutboxMessage
+	records int
+	deliver func(path string, payload []byte) error
+	wake    chan struct{}
+	mutex   sync.Mutex
+}
+
+// NewOutbox loads the outbox log stored at path, if any
+func NewOutbox(path string, deliver func(path string, payload []byte) error) (*Outbox, error) {
+	ob := &Outbox{
+		path:    path,
+		pending: make(map[string][]*OutboxMessage),
+		deliver: deliver,
+		wake:    make(chan struct{}, 1),
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ob, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The last record of a message wins
+	latest := make(map[uint64]outboxRecord)
+	decoder := json.NewDecoder(file)
+	for {
+		var record outboxRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			// A crash while appending leaves a truncated last record
+			logger.Warn("❌ Ignoring the rest of the outbox log:", err)
+			break
+		}
+		ob.records++
+		ob.nextId = max(ob.nextId, record.Id)
+		latest[record.Id] = record
+	}
+
+	ids := slices.Sorted(maps.Keys(latest))
+	for _, id := range ids {
+		record := latest[id]
+		if record.Message == nil {
+			continue
+		}
+		switch record.Status {
+		case outboxPending:
+			ob.pending[record.Message.PeerId] = append(ob.pending[record.Message.PeerId], record.Message)
+		case outboxDead:
+			ob.dead = append(ob.dead, record.Message)
+		}
+	}
+	if len(ob.dead) > outboxDeadCapacity {
+		ob.dead = ob.dead[len(ob.dead)-outboxDeadCapacity:]
+	}
+	return ob, nil
+}
+
+// persist appends the state of a message to the log, or rewrites the log
+// when most of its records are stale; callers must hold the mutex
+func (ob *Outbox) persist(status string, message *OutboxMessage) {
+	live := len(ob.dead)
+	for _, queue := range ob.pending {
+		live += len(queue)
+	}
+	if ob.records >= max(outboxCompactMinRecords, 2*live) {
+		ob.compact()
+		return
+	}
+
+	record := outboxRecord{Id: message.Id, Status: status}
+	if status != outboxDelivered {
+		record.Message = message
+	}
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = appendFile(ob.path, append(data, '\n'))
+	}
+	if err != nil {
+		logger.Warn("❌ Error saving outbox:", err)
+		return
+	}
+	ob.records++
+}
+
+// compact rewrites the log with one record per pending or dead message;
+// callers must hold the mutex
+func (ob *Outbox) compact() {
+	var records []outboxRecord
+	for _, queue := range ob.pending {
+		for _, message := range queue {
+			records = append(records, outboxRecord{Id: message.Id, Status: outboxPending, Message: message})
+		}
+	}
+	for _, message := range ob.dead {
+		records = append(records, outboxRecord{Id: message.Id, Status: outboxDead, Message: message})
+	}
+	// Keep the latest ID so it is not handed out again
+	if len(records) == 0 && ob.nextId > 0 {
+		records = append(records, outboxRecord{Id: ob.nextId, Status: outboxDelivered})
+	}
+
+	var data []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			logger.Warn("❌ Error encoding outbox:", err)
+			return
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := writeFileAtomic(ob.path, data); err != nil {
+		logger.Warn("❌ Error saving outbox:", err)
+		return
+	}
+	ob.records = len(records)
+}
+
+func (ob *Outbox) notify() {
+	select {
+	case ob.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue stores a notification for delivery to clientApiUrl + path
+func (ob *Outbox) Enqueue(peerId string, path string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ob.mutex.Lock()
+	pending := 0
+	for _, queue := range ob.pending {
+		pending += len(queue)
+	}
+	if pending >= outboxCapacity {
+		ob.mutex.Unlock()
+		return errOutboxFull
+	}
+	ob.nextId++
+	now := time.Now()
+	message := &OutboxMessage{
+		Id:          ob.nextId,
+		PeerId:      peerId,
+		Path:        path,
+		Payload:     data,
+		NextAttempt: now,
+		CreatedAt:   now,
+	}
+	ob.pending[peerId] = append(ob.pending[peerId], message)
+	ob.persist(outboxPending, message)
+	ob.mutex.Unlock()
+
+	ob.notify()
+	return nil
+}
+
+// Run delivers due messages until the process exits
+func (ob *Outbox) Run() {
+	for {
+		wait := ob.deliverDue()
+		select {
+		case <-ob.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// deliverDue attempts the head message of every peer queue that is due and
+// returns how long to wait until the next one is
+func (ob *Outbox) deliverDue() time.Duration {
+	ob.mutex.Lock()
+	var due []*OutboxMessage
+	now := time.Now()
+	for _, queue := range ob.pending {
+		if len(queue) > 0 && !queue[0].NextAttempt.After(now) {
+			due = append(due, queue[0])
+		}
+	}
+	ob.mutex.Unlock()
+
+	for _, message := range due {
+		err := ob.deliver(message.Path, message.Payload)
+		ob.complete(message, err)
+	}
+
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+	wait := outboxMaxBackoff
+	now = time.Now()
+	for _, queue := range ob.pending {
+		if len(queue) > 0 {
+			wait = min(wait, max(queue[0].NextAttempt.Sub(now), 0))
+		}
+	}
+	return wait
+}
+
+// complete records the outcome of a delivery attempt
+func (ob *Outbox) complete(message *OutboxMessage, err error) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	// A replayed message may have been put in front of it meanwhile
+	if !slices.Contains(ob.pending[message.PeerId], message) {
+		return
+	}
+
+	if err == nil {
+		logger.Info("✅ Successfully notified external API about ", message.Path, " from peer ", message.PeerId)
+		ob.dequeue(message)
+		ob.persist(outboxDelivered, message)
+		return
+	}
+
+	message.Attempts++
+	message.LastError = err.Error()
+	if message.Attempts >= outboxMaxAttempts {
+		logger.Warn("❌ Giving up on notification ", message.Id, " for peer ", message.PeerId, " after ", message.Attempts, " attempts:", err)
+		ob.dequeue(message)
+		ob.dead = append(ob.dead, message)
+		if len(ob.dead) > outboxDeadCapacity {
+			ob.dead = slices.Clone(ob.dead[len(ob.dead)-outboxDeadCapacity:])
+		}
+		ob.persist(outboxDead, message)
+		return
+	}
+	backoff := min(outboxInitialBackoff<<(message.Attempts-1), outboxMaxBackoff)
+	message.NextAttempt = time.Now().Add(backoff)
+	logger.Warn("❌ Failed to notify external API, retrying in ", backoff, ":", err)
+	ob.persist(outboxPending, message)
+}
+
+// dequeue drops a message from its peer queue; callers must hold the mutex
+func (ob *Outbox) dequeue(message *OutboxMessage) {
+	queue := slices.DeleteFunc(ob.pending[message.PeerId], func(m *OutboxMessage) bool { return m == message })
+	if len(queue) == 0 {
+		delete(ob.pending, message.PeerId)
+		return
+	}
+	ob.pending[message.PeerId] = queue
+}
+
+// Snapshot returns copies of the pending and dead-lettered messages
+func (ob *Outbox) Snapshot() (pending []OutboxMessage, dead []OutboxMessage) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	for _, queue := range ob.pending {
+		for _, message := range queue {
+			pending = append(pending, *message)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Id < pending[j].Id })
+	for _, message := range ob.dead {
+		dead = append(dead, *message)
+	}
+	return pending, dead
+}
+
+// Replay moves dead-lettered messages back to their original position in
+// their peer queue, which is ordered by ID. With id 0 every dead message is
+// replayed. It returns how many were moved.
+func (ob *Outbox) Replay(id uint64) int {
+	ob.mutex.Lock()
+	var replayed []*OutboxMessage
+	ob.dead = slices.DeleteFunc(ob.dead, func(message *OutboxMessage) bool {
+		if id != 0 && message.Id != id {
+			return false
+		}
+		replayed = append(replayed, message)
+		return true
+	})
+	for _, message := range replayed {
+		message.Attempts = 0
+		message.NextAttempt = time.Now()
+		queue := ob.pending[message.PeerId]
+		i, _ := slices.BinarySearchFunc(queue, message.Id, func(m *OutboxMessage, id uint64) int {
+			return cmp.Compare(m.Id, id)
+		})
+		ob.pending[message.PeerId] = slices.Insert(queue, i, message)
+		ob.persist(outboxPending, message)
+	}
+	ob.mutex.Unlock()
+
+	ob.notify()
+	return len(replayed)
+}
+
+// deliverToClientApi posts a signed notification to the client API
+func deliverToClientApi(path string, payload []byte) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	req, err := http.NewRequest(http.MethodPost, clientApiUrl+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhookRequest(req, payload)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("external API returned error status: %d", resp.StatusCode)
+	}
+	return nil
+}
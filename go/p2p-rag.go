@@ -2,14 +2,15 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -58,44 +59,61 @@ var topic *pubsub.Topic
 var globalHost host.Host
 var clientApiUrl string
 
-// notifyExternalApiAboutGossipedTopic sends an HTTP request to notify an external API about a gossiped topic
-func notifyExternalApiAboutGossipedTopic(expertiseData Expertise, peerId string) {
-	apiExpertiseUrl := clientApiUrl + "/expertise"
+// Embedding model used for vectors this node computes itself
+var embeddingModel = "nomic-embed-text"
 
-	logger.Info("📡 Notifying external API about gossiped topic:", "from peer: ", peerId, " to ", apiExpertiseUrl)
-	// Use the Gin HTTP client to make the POST request
-	// This is a non-blocking call to avoid slowing down the gossip process
-	go func() {
-		client := &http.Client{Timeout: 5 * time.Second}
+// notifyExternalApiAboutExpertise queues a notification to the external API about a change of a peer's expertise.
+// The outbox delivers it in the background so the gossip process is never slowed down.
+func notifyExternalApiAboutExpertise(notification ExpertiseNotification) {
+	logger.Info("📡 Notifying external API about ", notification.Event, " (", len(notification.Embeddings), " embeddings) from peer: ", notification.NodeId, " to ", clientApiUrl+"/expertise")
 
-		// Create a payload with topic data and peer ID
-		payload := struct {
-			NodeId     string      `json:"nodeId"`
-			Embeddings []Embedding `json:"embeddings"`
-		}{
-			NodeId:     peerId,
-			Embeddings: expertiseData.Embeddings,
-		}
+	events.Publish(notification.Event, notification.NodeId, notification)
+	if err := outbox.Enqueue(notification.NodeId, "/expertise", notification); err != nil {
+		logger.Warn("❌ Failed to queue expertise notification:", err)
+	}
+}
 
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			logger.Warn("❌ Failed to marshal topic data:", err)
-			return
-		}
+// notifyExternalApiAboutAvailability queues a notification to the external API that a peer
+// stopped or resumed answering queries, so it can stop or resume routing to it.
+func notifyExternalApiAboutAvailability(peerId string, available bool) {
+	event := nodeAvailable
+	if !available {
+		event = nodeUnavailable
+	}
+	notifyExternalApiAboutExpertise(ExpertiseNotification{Event: event, NodeId: peerId, Available: &available})
+}
 
-		resp, err := client.Post(apiExpertiseUrl, "application/json", bytes.NewReader(jsonData))
-		if err != nil {
-			logger.Warn("❌ Failed to notify external API:", err)
-			return
-		}
-		defer resp.Body.Close()
+// publishExpertise gossips an expertise announcement right away. Before the
+// p2p side is up this is a no-op; the periodic gossip picks it up later.
+func publishExpertise(expertiseData Expertise) error {
+	if topic == nil {
+		logger.Warn("❌ Couldn't gossip topic from API: p2p not initialized yet")
+		return nil
+	}
 
-		if resp.StatusCode >= 400 {
-			logger.Warn("❌ External API returned error status:", resp.StatusCode)
-		} else {
-			logger.Info("✅ Successfully notified external API about topic")
-		}
-	}()
+	// Serialize the topic data to JSON
+	expertisePayload := struct {
+		Data         Expertise         `json:"data"`
+		Capabilities QueryCapabilities `json:"capabilities"`
+		Available    bool              `json:"available"`
+	}{
+		Data:         expertiseData,
+		Capabilities: localQueryCapabilities(),
+		Available:    localAvailability(),
+	}
+
+	jsonData, err := json.Marshal(expertisePayload)
+	if err != nil {
+		logger.Warn("❌ Error marshaling topic data:", err)
+		return fmt.Errorf("failed to serialize topic data: %w", err)
+	}
+
+	if err := topic.Publish(context.Background(), jsonData); err != nil {
+		logger.Warn("❌ Error publishing topic from API:", err)
+		return err
+	}
+	logger.Info("📡 Gossiped topic from API")
+	return nil
 }
 
 // QueryRequest represents a request to query a peer
@@ -105,6 +123,10 @@ type QueryRequest struct {
 	Model        string `json:"model"`
 	MatchCount   int    `json:"match_count"`
 	Vector       Vector `json:"vector"`
+
+	// Optional metadata filter and raw text for hybrid/keyword search
+	Filter    QueryFilter `json:"filter,omitempty"`
+	QueryText string      `json:"query_text,omitempty"`
 }
 
 // QueryResponse represents a response from a peer query
@@ -112,6 +134,10 @@ type QueryResponse struct {
 	Success bool        `json:"success"`
 	Error   string      `json:"error,omitempty"`
 	Result  interface{} `json:"result,omitempty"`
+
+	// Signature over the query ID, vector hash and result by the responding node
+	Signature []byte `json:"signature,omitempty"`
+	Signer    string `json:"signer,omitempty"`
 }
 
 // setupQueryProtocol initializes the query protocol handler
@@ -129,9 +155,26 @@ func handleQueryStream(stream network.Stream) {
 
 	// Read the request from the stream
 	var request QueryRequest
+	start := time.Now()
+	served := false
+	audit := QueryAuditEntry{Direction: queryInbound, PeerId: stream.Conn().RemotePeer().String()}
+	defer func() {
+		events.Publish(eventQueryServed, stream.Conn().RemotePeer().String(), gin.H{
+			"queryId":     request.QueryId,
+			"success":     served,
+			"duration_ms": time.Since(start).Milliseconds(),
+		})
+		audit.Time = start
+		audit.QueryId = request.QueryId
+		audit.ExpertiseKey = request.ExpertiseKey
+		audit.MatchCount = request.MatchCount
+		audit.LatencyMs = time.Since(start).Milliseconds()
+		queryAudit.Record(audit)
+	}()
 	decoder := json.NewDecoder(rw.Reader)
 	if err := decoder.Decode(&request); err != nil {
 		logger.Warn("❌ Error decoding query request:", err)
+		audit.ErrorCode = queryErrBadRequest
 		sendErrorResponse(rw, "Failed to decode request")
 		return
 	}
@@ -141,88 +184,50 @@ func handleQueryStream(stream network.Stream) {
 	logger.Info("📥 Received query request from peer:", stream.Conn().RemotePeer())
 	logger.Info("📥 Request details:", string(requestJson))
 
-	// Forward the query to the local search API
-	result, err := forwardQueryToLocalAPI(request)
+	// Reject options the local API cannot honour
+	if err := validateQueryOptions(request.Filter, request.QueryText); err != nil {
+		audit.ErrorCode = queryErrInvalidQuery
+		sendErrorResponse(rw, err.Error())
+		return
+	}
+
+	// Run the query against the local search backend
+	result, err := searchBackend.Query(context.Background(), request)
 	if err != nil {
 		logger.Warn("❌ Error forwarding query to local API:", err)
+		audit.ErrorCode = queryErrBackend
 		sendErrorResponse(rw, fmt.Sprintf("Failed to process query: %s", err.Error()))
 		return
 	}
+	audit.ResultCount = countResultDocuments(result)
 
-	// Send the response back
-	response := QueryResponse{
-		Success: true,
-		Result:  result,
+	// Sign the result so the requester can prove where it came from
+	response, err := signLocalQueryResult(request, result)
+	if err != nil {
+		logger.Warn("❌ Error signing query result:", err)
+		audit.ErrorCode = queryErrSigning
+		sendErrorResponse(rw, "Failed to sign result")
+		return
 	}
 
+	// Send the response back
 	encoder := json.NewEncoder(rw.Writer)
 	if err := encoder.Encode(response); err != nil {
 		logger.Warn("❌ Error encoding query response:", err)
+		audit.ErrorCode = queryErrSend
 		return
 	}
 
 	if err := rw.Writer.Flush(); err != nil {
 		logger.Warn("❌ Error flushing response:", err)
+		audit.ErrorCode = queryErrSend
 		return
 	}
+	served = true
 
 	logger.Info("📤 Sent query response to peer:", stream.Conn().RemotePeer())
 }
 
-// forwardQueryToLocalAPI forwards the query to the local search API
-func forwardQueryToLocalAPI(request QueryRequest) (interface{}, error) {
-	// Construct the query payload
-	type embeddingPayload struct {
-		Key        string `json:"expertise_key"`
-		Model      string `json:"model"`
-		Vector     Vector `json:"vector"`
-		MatchCount int    `json:"match_count"`
-	}
-
-	queryPayload := struct {
-		QueryId   string           `json:"queryId"`
-		Embedding embeddingPayload `json:"embedding"`
-	}{
-		QueryId: request.QueryId,
-		Embedding: embeddingPayload{
-			Key:        request.ExpertiseKey,
-			Model:      request.Model,
-			Vector:     request.Vector,
-			MatchCount: request.MatchCount,
-		},
-	}
-
-	// Convert the payload to JSON
-	jsonData, err := json.Marshal(queryPayload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal query data: %w", err)
-	}
-
-	// Debug log to check what's being sent
-	//logger.Info("📤 Forwarding query to local API with payload:", string(jsonData))
-
-	// Send the query to the local search API
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Post(clientApiUrl+"/query", "application/json", bytes.NewReader(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to send query to search API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check for HTTP errors
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("search API returned error status: %d", resp.StatusCode)
-	}
-
-	// Parse and return the JSON response
-	var result interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to parse query response: %w", err)
-	}
-
-	return result, nil
-}
-
 // sendErrorResponse sends an error response back to the peer
 func sendErrorResponse(rw *bufio.ReadWriter, errorMsg string) {
 	response := QueryResponse{
@@ -241,23 +246,63 @@ func sendErrorResponse(rw *bufio.ReadWriter, errorMsg string) {
 	}
 }
 
-// queryRemotePeer sends a query to a remote peer and returns the response
-func queryRemotePeer(ctx context.Context, host host.Host, peerIdStr string, request QueryRequest) (interface{}, error) {
+// queryRemotePeer sends a query to a remote peer and returns the verified response
+func queryRemotePeer(ctx context.Context, host host.Host, peerIdStr string, request QueryRequest) (*QueryResponse, error) {
 	// Parse the peer ID string
 	peerID, err := peer.Decode(peerIdStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid peer ID: %w", err)
 	}
 
+	// Every query outcome feeds into the peer's reputation
+	start := time.Now()
+	outcome := QueryOutcome{}
+	audit := QueryAuditEntry{
+		Direction:    queryOutbound,
+		QueryId:      request.QueryId,
+		PeerId:       peerIdStr,
+		ExpertiseKey: request.ExpertiseKey,
+		MatchCount:   request.MatchCount,
+	}
+	defer func() {
+		outcome.Latency = time.Since(start)
+		audit.Time = start
+		audit.LatencyMs = outcome.Latency.Milliseconds()
+		audit.ResultCount = outcome.ResultCount
+		if audit.ErrorCode != "" && ctx.Err() != nil {
+			audit.ErrorCode = queryErrCancelled
+		}
+		queryAudit.Record(audit)
+
+		// A cancelled query (e.g. a hedged request that lost) says nothing about the peer
+		if !outcome.Success && !outcome.Malformed && ctx.Err() != nil {
+			return
+		}
+		reputation.Record(peerID, outcome)
+		if outcome.Success {
+			queryLatencies.Observe(outcome.Latency)
+		}
+		events.Publish(eventQueryCompleted, peerIdStr, gin.H{
+			"queryId":    request.QueryId,
+			"success":    outcome.Success,
+			"latency_ms": outcome.Latency.Milliseconds(),
+		})
+	}()
+
 	// Check if we're connected to this peer
 	if host.Network().Connectedness(peerID) != network.Connected {
-		return nil, fmt.Errorf("not connected to peer %s", peerIdStr)
+		audit.ErrorCode = queryErrNotConnected
+		return nil, fmt.Errorf("%w: not connected to peer %s", errPeerUnreachable, peerIdStr)
 	}
 
 	// Open a new stream to the peer
 	stream, err := host.NewStream(ctx, peerID, protocol.ID(queryProtocolID))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open stream to peer: %w", err)
+		audit.ErrorCode = queryErrStream
+		if isTransientDialError(err) {
+			return nil, fmt.Errorf("%w: %w: %w", errPeerUnreachable, errOpenStream, err)
+		}
+		return nil, fmt.Errorf("%w: %w", errOpenStream, err)
 	}
 	defer stream.Close()
 
@@ -272,10 +317,12 @@ func queryRemotePeer(ctx context.Context, host host.Host, peerIdStr string, requ
 	// Send the request
 	encoder := json.NewEncoder(rw.Writer)
 	if err := encoder.Encode(request); err != nil {
+		audit.ErrorCode = queryErrSend
 		return nil, fmt.Errorf("failed to encode query request: %w", err)
 	}
 
 	if err := rw.Writer.Flush(); err != nil {
+		audit.ErrorCode = queryErrSend
 		return nil, fmt.Errorf("failed to send query request: %w", err)
 	}
 
@@ -285,47 +332,76 @@ func queryRemotePeer(ctx context.Context, host host.Host, peerIdStr string, requ
 	var response QueryResponse
 	decoder := json.NewDecoder(rw.Reader)
 	if err := decoder.Decode(&response); err != nil {
+		outcome.Malformed = true
+		audit.ErrorCode = queryErrMalformedResponse
 		return nil, fmt.Errorf("failed to decode query response: %w", err)
 	}
 
 	// Check if the query was successful
 	if !response.Success {
+		audit.ErrorCode = queryErrRemote
 		return nil, fmt.Errorf("query failed on peer: %s", response.Error)
 	}
 
+	// Check that the result was signed by the peer we queried
+	if response.Signer != peerID.String() {
+		outcome.Malformed = true
+		audit.ErrorCode = queryErrBadSignature
+		return nil, fmt.Errorf("result signed by %q instead of %s", response.Signer, peerID)
+	}
+	if err := verifyQueryResult(stream.Conn().RemotePublicKey(), request, response.Result, response.Signature); err != nil {
+		outcome.Malformed = true
+		audit.ErrorCode = queryErrBadSignature
+		return nil, err
+	}
+
 	logger.Info("📥 Received query response from peer:", peerID)
 
-	return response.Result, nil
+	outcome.Success = true
+	outcome.ResultCount = countResultDocuments(response.Result)
+
+	return &response, nil
+}
+
+// respondAfterIngestion recomputes our expertise from the stored documents
+// if the caller asked for it with ?recompute_expertise=true
+func respondAfterIngestion(c *gin.Context, response gin.H) {
+	if c.Query("recompute_expertise") == "true" {
+		if err := recomputeExpertise(memoryIndex); err != nil {
+			logger.Warn("❌ Error recomputing expertise:", err)
+			response["expertise_error"] = err.Error()
+		} else {
+			response["expertise_recomputed"] = true
+		}
+	}
+	c.JSON(200, response)
+}
+
+// respondApiError reports an error of a shared API operation
+func respondApiError(c *gin.Context, err error) {
+	apiErr := asApiError(err)
+	if apiErr.Details == "" {
+		c.JSON(apiErr.Status, gin.H{"error": apiErr.Message})
+		return
+	}
+	c.JSON(apiErr.Status, gin.H{"error": apiErr.Message, "details": apiErr.Details})
 }
 
-func startWebApi() {
+func startWebApi(config Config) {
 	gin.SetMode(gin.ReleaseMode)
 
 	r := gin.Default()
+	r.Use(requireApiToken())
 
 	r.GET("/expertise", func(c *gin.Context) {
-		myExpertiseMutex.RLock()
-		// Return a map of topic keys to their vector data
-		topics := make([]Expertise, len(myExpertise))
-		for _, topicData := range myExpertise {
-			topics = append(topics, topicData)
-		}
-		myExpertiseMutex.RUnlock()
-
 		c.JSON(200, gin.H{
-			"topics": topics,
+			"topics": listExpertise(),
 		})
 	})
 
 	r.POST("/expertise", func(c *gin.Context) {
-		type EmbeddingJson struct {
-			Key       string    `json:"key" binding:"required"`
-			Model     string    `json:"model" binding:"required"`
-			Expertise string    `json:"expertise" binding:"required"`
-			Vector    []float64 `json:"vector" binding:"required"`
-		}
 		type ExpertiseRequest struct {
-			Embeddings []EmbeddingJson `json:"embeddings" binding:"required"`
+			Embeddings []EmbeddingInput `json:"embeddings" binding:"required"`
 		}
 
 		var request ExpertiseRequest
@@ -334,146 +410,572 @@ func startWebApi() {
 			return
 		}
 
-		// Validate vectors
-		for _, emb := range request.Embeddings {
-			if len(emb.Vector) != vectorDimension {
-				c.JSON(400, gin.H{"error": fmt.Sprintf("Each vector must have exactly %d values", vectorDimension)})
+		expertiseData, err := announceExpertise(c.Request.Context(), request.Embeddings)
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message":        "Expertise received and gossiped",
+			"embeddingCount": len(expertiseData.Embeddings),
+		})
+	})
+
+	// Reports the optional query features this node supports
+	r.GET("/capabilities", func(c *gin.Context) {
+		c.JSON(200, localQueryCapabilities())
+	})
+
+	// Store document chunks in the built-in index
+	r.POST("/documents", func(c *gin.Context) {
+		if memoryIndex == nil {
+			c.JSON(409, gin.H{"error": "Document ingestion requires -backend memory"})
+			return
+		}
+
+		var request struct {
+			Documents []DocumentInput `json:"documents" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request format : " + err.Error()})
+			return
+		}
+
+		// Validate everything first so a bad chunk doesn't leave a partial import
+		for _, input := range request.Documents {
+			if _, err := input.ToDocument(); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
 				return
 			}
 		}
+		if err := ingestDocuments(memoryIndex, request.Documents); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to store documents", "details": err.Error()})
+			return
+		}
 
-		// Create embeddings and copy data from request
-		embeddings := make([]Embedding, len(request.Embeddings))
-		for i, emb := range request.Embeddings {
-			embeddings[i] = Embedding{
-				Key:       emb.Key,
-				Expertise: emb.Expertise,
-				Model:     emb.Model,
-				Vector:    emb.Vector,
-			}
+		response := gin.H{"message": "Documents stored", "imported": len(request.Documents), "total": memoryIndex.Len()}
+		respondAfterIngestion(c, response)
+	})
+
+	// Bulk import of newline-delimited JSON documents
+	r.POST("/documents/bulk", func(c *gin.Context) {
+		if memoryIndex == nil {
+			c.JSON(409, gin.H{"error": "Document ingestion requires -backend memory"})
+			return
+		}
+
+		imported, failures, err := importNDJSON(memoryIndex, c.Request.Body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Failed to read import", "details": err.Error(), "imported": imported})
+			return
 		}
 
-		expertiseData := Expertise{
-			Embeddings: embeddings,
+		response := gin.H{"message": "Documents imported", "imported": imported, "failed": failures, "total": memoryIndex.Len()}
+		respondAfterIngestion(c, response)
+	})
+
+	// Document IDs default to "<url>#<chunk>", so the ID may contain slashes
+	r.DELETE("/documents/*id", func(c *gin.Context) {
+		if memoryIndex == nil {
+			c.JSON(409, gin.H{"error": "Document ingestion requires -backend memory"})
+			return
 		}
 
-		// Add to known topics
-		myExpertiseMutex.Lock()
-		myExpertise = append(myExpertise, expertiseData)
-		myExpertiseMutex.Unlock()
+		id := strings.TrimPrefix(c.Param("id"), "/")
+		if !memoryIndex.Delete(id) {
+			c.JSON(404, gin.H{"error": "Document not found"})
+			return
+		}
 
-		// Gossip the new topic immediately
-		if topic != nil {
-			// Serialize the topic data to JSON
-			expertisePayload := struct {
-				Data Expertise `json:"data"`
-			}{
-				Data: expertiseData,
-			}
+		respondAfterIngestion(c, gin.H{"message": "Document deleted", "total": memoryIndex.Len()})
+	})
 
-			jsonData, err := json.Marshal(expertisePayload)
-			if err != nil {
-				logger.Warn("❌ Error marshaling topic data:", err)
-				c.JSON(500, gin.H{"error": "Failed to serialize topic data", "details": err.Error()})
-				return
-			}
+	// Notifications waiting for or given up on delivery to the client API
+	r.GET("/admin/outbox", func(c *gin.Context) {
+		pending, dead := outbox.Snapshot()
+		c.JSON(200, gin.H{"pending": pending, "dead": dead})
+	})
 
-			err = topic.Publish(context.Background(), jsonData)
+	// Queue dead-lettered notifications again, all of them or ?id=<id>
+	r.POST("/admin/outbox/replay", func(c *gin.Context) {
+		var id uint64
+		if value := c.Query("id"); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, 64)
 			if err != nil {
-				logger.Warn("❌ Error publishing topic from API:", err)
-				c.JSON(500, gin.H{"error": "Failed to gossip topic", "details": err.Error()})
+				c.JSON(400, gin.H{"error": "Invalid id"})
 				return
 			}
-			logger.Info("📡 Gossiped topic from API")
-		} else {
-			logger.Warn("❌ Couldn't gossip topic from API: p2p not initialized yet")
+			id = parsed
 		}
 
-		c.JSON(200, gin.H{
-			"message":        "Expertise received and gossiped",
-			"embeddingCount": len(expertiseData.Embeddings),
-		})
+		replayed := outbox.Replay(id)
+		if id != 0 && replayed == 0 {
+			c.JSON(404, gin.H{"error": "Dead-lettered notification not found"})
+			return
+		}
+		c.JSON(200, gin.H{"replayed": replayed})
+	})
+
+	// Reports whether the search backend can currently answer queries
+	r.GET("/health", func(c *gin.Context) {
+		if err := backendHealth(c.Request.Context()); err != nil {
+			c.JSON(503, gin.H{"healthy": false, "available": localAvailability(), "error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"healthy": true, "available": localAvailability()})
 	})
 
 	// New endpoint for querying a remote peer
 	r.POST("/query", func(c *gin.Context) {
+		var request QueryInput
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request format : " + err.Error()})
+			return
+		}
 
-		type EmbeddingQuery struct {
-			ExpertiseKey string    `json:"expertise_key"`
-			Model        string    `json:"model"`
-			Vector       []float64 `json:"vector"`
-			MatchCount   int       `json:"match_count"`
+		req, response, err := runQuery(c.Request.Context(), request)
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+
+		// Return the query result along with its provenance
+		for name, value := range provenanceHeaders(req, *response) {
+			c.Header(name, value)
 		}
-		type QueryRequestAPI struct {
-			PeerId    string         `json:"nodeId" binding:"required"`
-			QueryId   string         `json:"queryId" binding:"required"`
-			Embedding EmbeddingQuery `json:"embedding" binding:"required"`
+		c.JSON(200, response.Result)
+	})
+
+	// Live node events as server-sent events, limited to ?type=a,b if given
+	r.GET("/events", func(c *gin.Context) {
+		types, err := parseEventTypes(c.QueryArray("type"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error(), "types": knownEventTypes})
+			return
+		}
+
+		ch, unsubscribe := events.Subscribe(types)
+		defer unsubscribe()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(200)
+		c.Writer.Flush()
+
+		// Comments keep proxies from closing an idle stream
+		keepalive := time.NewTicker(eventKeepaliveInterval)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-keepalive.C:
+				fmt.Fprint(c.Writer, ": keepalive\n\n")
+			case event := <-ch:
+				c.SSEvent(event.Type, event)
+			}
+			c.Writer.Flush()
+		}
+	})
+
+	// Identity and state of this node
+	r.GET("/node", func(c *gin.Context) {
+		c.JSON(200, nodeStatus(c.Request.Context(), config.Backend))
+	})
+
+	// Connected peers and peers that gossiped expertise to us
+	r.GET("/peers", func(c *gin.Context) {
+		c.JSON(200, gin.H{"peers": peerManager.Peers(globalHost)})
+	})
+
+	r.GET("/peers/:id", func(c *gin.Context) {
+		info, err := describePeer(c.Param("id"))
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+		c.JSON(200, info)
+	})
+
+	// Audit log of the queries sent, served and run locally
+	r.GET("/queries", func(c *gin.Context) {
+		filter, offset, limit, err := parseQueryAuditParams(c.Query)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		entries, total, err := queryAudit.Find(filter, offset, limit)
+		if err != nil {
+			logger.Warn("❌ Error reading query audit log:", err)
+			c.JSON(500, gin.H{"error": "Failed to read query audit log", "details": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"queries": entries, "total": total, "offset": offset, "limit": limit})
+	})
+
+	// Direct messages between nodes
+	r.POST("/messages/:peerId", func(c *gin.Context) {
+		var body struct {
+			Body string `json:"body" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		message, err := sendMessage(c.Request.Context(), c.Param("peerId"), body.Body)
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+		c.JSON(200, message)
+	})
+
+	r.GET("/messages", func(c *gin.Context) {
+		offset, limit, err := parsePage(c.Query)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		var since time.Time
+		if value := c.Query("since"); value != "" {
+			if since, err = time.Parse(time.RFC3339, value); err != nil {
+				c.JSON(400, gin.H{"error": "since must be an RFC 3339 time"})
+				return
+			}
+		}
+		messages, total := inbox.List(c.Query("from"), since, offset, limit)
+		c.JSON(200, gin.H{"messages": messages, "total": total, "offset": offset, "limit": limit})
+	})
+
+	r.DELETE("/messages/:peerId/:id", func(c *gin.Context) {
+		if !inbox.Delete(c.Param("peerId"), c.Param("id")) {
+			c.JSON(404, gin.H{"error": "Unknown message"})
+			return
+		}
+		c.Status(204)
+	})
+
+	// Steer connectivity by hand, see requests.md. The controls persist
+	// across restarts.
+	r.POST("/peers/connect", func(c *gin.Context) {
+		var body struct {
+			Address string `json:"address" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
 		}
-		var request QueryRequestAPI
+		info, err := connectPeer(c.Request.Context(), body.Address)
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+		c.JSON(200, info)
+	})
+
+	r.POST("/peers/:id/disconnect", func(c *gin.Context) {
+		info, err := disconnectPeer(c.Param("id"))
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+		c.JSON(200, info)
+	})
+
+	r.PUT("/peers/:id/ban", func(c *gin.Context) {
+		var body struct {
+			Duration string `json:"duration" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		info, err := banPeer(c.Param("id"), body.Duration)
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+		c.JSON(200, info)
+	})
+
+	r.DELETE("/peers/:id/ban", func(c *gin.Context) {
+		info, err := unbanPeer(c.Param("id"))
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+		c.JSON(200, info)
+	})
+
+	r.PUT("/peers/:id/protect", func(c *gin.Context) {
+		info, err := protectPeer(c.Param("id"), true)
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+		c.JSON(200, info)
+	})
+
+	r.DELETE("/peers/:id/protect", func(c *gin.Context) {
+		info, err := protectPeer(c.Param("id"), false)
+		if err != nil {
+			respondApiError(c, err)
+			return
+		}
+		c.JSON(200, info)
+	})
+
+	// Reputation of the peers we have queried, best first
+	r.GET("/peers/reputation", func(c *gin.Context) {
+		c.JSON(200, gin.H{"peers": reputation.Scores()})
+	})
+
+	// Rate a document returned for a query
+	r.POST("/feedback", func(c *gin.Context) {
+		type FeedbackRequestAPI struct {
+			QueryId      string `json:"queryId" binding:"required"`
+			DocumentId   string `json:"documentId" binding:"required"`
+			PeerId       string `json:"nodeId" binding:"required"`
+			ExpertiseKey string `json:"expertise_key"`
+			Rating       int    `json:"rating" binding:"required"`
+			Share        bool   `json:"share"`
+		}
+		var request FeedbackRequestAPI
 		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(400, gin.H{"error": "Invalid request format : " + err.Error()})
 			return
 		}
 
-		// Validate vector length
-		if len(request.Embedding.Vector) != vectorDimension {
-			c.JSON(400, gin.H{"error": fmt.Sprintf("Vector must have exactly %d values", vectorDimension)})
+		feedback := Feedback{
+			QueryId:      request.QueryId,
+			DocumentId:   request.DocumentId,
+			PeerId:       request.PeerId,
+			ExpertiseKey: request.ExpertiseKey,
+			Rating:       request.Rating,
+			Time:         time.Now().UTC(),
+		}
+		if err := feedback.Validate(); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Only rate documents a peer actually returned to us
+		documents, err := answeredDocuments(feedback.PeerId, feedback.QueryId, queryOutbound, queryLocal)
+		if errors.Is(err, errUnansweredFeedbackQuery) {
+			c.JSON(404, gin.H{"error": "No answered query from this peer matches the feedback"})
+			return
+		}
+		if err != nil {
+			logger.Warn("❌ Error reading query audit log:", err)
+			c.JSON(500, gin.H{"error": "Failed to check the query", "details": err.Error()})
+			return
+		}
+
+		if err := givenFeedback.Check(feedback, documents); err != nil {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Optionally let the answering peer know how useful its content was.
+		// The rating is only stored once shared, so a failed share can be retried.
+		shared := false
+		if request.Share && globalHost != nil && request.PeerId != globalHost.ID().String() {
+			sharedFeedback := feedback
+			sharedFeedback.From = globalHost.ID().String()
+			if err := sendFeedbackToPeer(c.Request.Context(), globalHost, sharedFeedback); err != nil {
+				logger.Warn("❌ Error sending feedback to peer:", err)
+				c.JSON(502, gin.H{"error": "Feedback could not be sent to peer and was not stored", "details": err.Error()})
+				return
+			}
+			shared = true
+		}
+
+		err = givenFeedback.Add(feedback, documents)
+		if errors.Is(err, errDuplicateFeedback) || errors.Is(err, errTooMuchFeedback) {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Warn("❌ Error storing feedback:", err)
+			c.JSON(500, gin.H{"error": "Failed to store feedback", "details": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"message": "Feedback stored", "shared": shared})
+	})
+
+	// Feedback we gave to other peers and received on our own answers
+	r.GET("/feedback", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"given":    givenFeedback.Aggregates(),
+			"received": receivedFeedback.Aggregates(),
+		})
+	})
+
+	// Endpoint for asking a peer to answer a question with its own LLM
+	r.POST("/answer", func(c *gin.Context) {
+		type AnswerRequestAPI struct {
+			PeerId       string   `json:"nodeId" binding:"required"`
+			QueryId      string   `json:"queryId" binding:"required"`
+			Question     string   `json:"question" binding:"required"`
+			Context      []string `json:"context"`
+			ExpertiseKey string   `json:"expertise_key"`
+			Stream       bool     `json:"stream"`
+		}
+		var request AnswerRequestAPI
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request format : " + err.Error()})
 			return
 		}
 
-		// Get the host from the global variable
 		if globalHost == nil {
 			c.JSON(500, gin.H{"error": "P2P host not initialized yet"})
 			return
 		}
-		vector := Vector(request.Embedding.Vector)
 
-		req := QueryRequest{
+		req := AnswerRequest{
 			QueryId:      request.QueryId,
-			ExpertiseKey: request.Embedding.ExpertiseKey,
-			Model:        request.Embedding.Model,
-			MatchCount:   request.Embedding.MatchCount,
-			Vector:       vector,
+			Question:     request.Question,
+			Context:      request.Context,
+			ExpertiseKey: request.ExpertiseKey,
+			Stream:       request.Stream,
 		}
 
-		if request.PeerId == globalHost.ID().String() {
-			logger.Info("🔍 Querying self")
-			var result interface{}
-			var err error
+		ask := func(emit func(AnswerResponse) error) error {
+			if request.PeerId == globalHost.ID().String() {
+				logger.Info("🔍 Asking self")
+				return forwardAnswerToLocalAPI(c.Request.Context(), req, emit)
+			}
+			logger.Info("🔍 Asking peer:", request.PeerId)
+			return askRemotePeer(c.Request.Context(), globalHost, request.PeerId, req, emit)
+		}
 
-			result, err = forwardQueryToLocalAPI(req)
+		// Streamed answers are relayed as server-sent events
+		if request.Stream {
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			err := ask(func(response AnswerResponse) error {
+				if response.Done {
+					c.SSEvent("done", gin.H{"answer": response.Answer, "citations": response.Citations})
+				} else {
+					c.SSEvent("token", response.Token)
+				}
+				c.Writer.Flush()
+				return c.Request.Context().Err()
+			})
 			if err != nil {
-				logger.Warn("❌ Error querying self:", err)
-				c.JSON(500, gin.H{"error": "Failed to query self", "details": err.Error()})
-				return
-			} else {
-				logger.Info("✅ Successfully queried self")
-				c.JSON(200, result)
+				logger.Warn("❌ Error streaming answer:", err)
+				c.SSEvent("error", gin.H{"error": "Failed to get answer", "details": err.Error()})
+				c.Writer.Flush()
 			}
-		} else {
-			logger.Info("🔍 Querying peer:", request.PeerId)
+			return
+		}
 
-			// Send the query to the remote peer via libp2p
-			result, err := queryRemotePeer(c.Request.Context(), globalHost, request.PeerId, req)
-			if err != nil {
-				logger.Warn("❌ Error querying peer:", err)
-				c.JSON(500, gin.H{"error": "Failed to query peer", "details": err.Error()})
+		var answer AnswerResponse
+		err := ask(func(response AnswerResponse) error {
+			answer = response
+			return nil
+		})
+		if err != nil {
+			logger.Warn("❌ Error getting answer:", err)
+			c.JSON(500, gin.H{"error": "Failed to get answer", "details": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"answer": answer.Answer, "citations": answer.Citations})
+	})
+
+	// Endpoint for sending several query vectors to a peer over one stream
+	r.POST("/query/batch", func(c *gin.Context) {
+		type EmbeddingQuery struct {
+			ExpertiseKey string    `json:"expertise_key"`
+			Vector       []float64 `json:"vector"`
+			MatchCount   int       `json:"match_count"`
+		}
+		type BatchQueryRequestAPI struct {
+			PeerId     string           `json:"nodeId" binding:"required"`
+			QueryId    string           `json:"queryId" binding:"required"`
+			Model      string           `json:"model"`
+			Embeddings []EmbeddingQuery `json:"embeddings" binding:"required"`
+		}
+		var request BatchQueryRequestAPI
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request format : " + err.Error()})
+			return
+		}
+
+		req := BatchQueryRequest{
+			QueryId: request.QueryId,
+			Model:   request.Model,
+			Items:   make([]BatchQueryItem, len(request.Embeddings)),
+		}
+		for i, emb := range request.Embeddings {
+			if len(emb.Vector) != vectorDimension {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("Each vector must have exactly %d values", vectorDimension)})
 				return
 			}
+			req.Items[i] = BatchQueryItem{
+				ExpertiseKey: emb.ExpertiseKey,
+				MatchCount:   emb.MatchCount,
+				Vector:       Vector(emb.Vector),
+			}
+		}
+		if err := validateBatchQuery(req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		for _, item := range req.Items {
+			recentQueries.Record(item.Vector)
+		}
 
-			// Return the query result
-			c.JSON(200, result)
+		if globalHost == nil {
+			c.JSON(500, gin.H{"error": "P2P host not initialized yet"})
+			return
+		}
+
+		if request.PeerId == globalHost.ID().String() {
+			logger.Info("🔍 Batch querying self")
+			c.JSON(200, gin.H{"results": processBatchQuery(c.Request.Context(), req)})
+			return
 		}
+
+		logger.Info("🔍 Batch querying peer:", request.PeerId)
+		results, err := queryRemotePeerBatch(c.Request.Context(), globalHost, request.PeerId, req)
+		if err != nil {
+			logger.Warn("❌ Error batch querying peer:", err)
+			c.JSON(500, gin.H{"error": "Failed to query peer", "details": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"results": results})
 	})
 
-	r.Run(":8888")
+	if err := serveApi(r, config.ApiListen, config.ApiTLSCert, config.ApiTLSKey); err != nil {
+		logger.Warn("❌ HTTP API stopped:", err)
+	}
 }
 
 var peerManager = NewPeerManager()
+var reputation *ReputationStore
+var knownExpertise = NewExpertiseRegistry()
+var outbox *Outbox
+var givenFeedback *FeedbackStore
+var receivedFeedback *FeedbackStore
 
 func main() {
 	log.SetAllLoggers(log.LevelError)
 	log.SetLogLevel(systemName, "info")
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImportCommand(os.Args[2:]); err != nil {
+			fmt.Println("Import failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	help := flag.Bool("h", false, "Display Help")
 	printKey := flag.Bool("pk", false, "Prints a new private key")
 	config, err := ParseFlags()
@@ -482,9 +984,10 @@ func main() {
 	}
 
 	if *help {
-		fmt.Println("This program demonstrates a simple p2p chat application using libp2p")
+		fmt.Println("p2p-rag runs a node of a peer-to-peer retrieval network using libp2p. It gossips the expertise of its documents,")
+		fmt.Println("answers queries from its search backend, routes queries to matching peers and serves an HTTP and gRPC API.")
 		fmt.Println()
-		fmt.Println("Usage: Run './p2p-rag in two different terminals. Let them connect to the bootstrap nodes, announce themselves and connect to the peers")
+		fmt.Println("Usage: Run './p2p-rag' on each node. Nodes connect to the bootstrap nodes, find each other through the rendezvous string and gossip their expertise")
 		fmt.Println("Example for listening on all local IP addresses on a random TCP port:")
 		fmt.Println("./p2p-rag -listen /ip4/0.0.0.0/tcp/0 ")
 		fmt.Println("You can also pass a base64 private key using the -key flag, otherwise a new Ed25519 key will be created and printed.")
@@ -498,7 +1001,77 @@ func main() {
 		return
 	}
 
-	go startWebApi()
+	clientApiUrl = strings.TrimRight(config.ClientApiUrl, "/")
+	batchWorkers = config.BatchWorkers
+	hedgePercentile = config.HedgePercentile
+	queryRetries = config.QueryRetries
+	embeddingModel = config.EmbeddingModel
+	outboxMaxAttempts = config.OutboxMaxAttempts
+	apiTokens = config.ApiTokens
+	if len(apiTokens) == 0 {
+		logger.Warn("🔓 No -api-token set, anyone reaching the HTTP and gRPC APIs can use them")
+	}
+	webhookSecret = []byte(config.WebhookSecret)
+	rendezvousString = config.RendezvousString
+	if len(webhookSecret) == 0 {
+		logger.Warn("🔓 No -webhook-secret set, requests to the client API are not signed")
+	}
+
+	if err := os.MkdirAll(config.DataDir, 0o755); err != nil {
+		panic(err)
+	}
+
+	reputation, err = NewReputationStore(filepath.Join(config.DataDir, "reputation.json"))
+	if err != nil {
+		panic(err)
+	}
+	go saveReputationPeriodically(reputation)
+
+	givenFeedback, err = NewFeedbackStore(filepath.Join(config.DataDir, "feedback.jsonl"))
+	if err != nil {
+		panic(err)
+	}
+	receivedFeedback, err = NewFeedbackStore(filepath.Join(config.DataDir, "feedback-received.jsonl"))
+	if err != nil {
+		panic(err)
+	}
+
+	queryAudit = NewQueryAuditLog(filepath.Join(config.DataDir, "queries.jsonl"), config.QueryLogRetention)
+	go compactQueryAuditPeriodically(queryAudit)
+
+	peerControls, err = NewPeerControls(filepath.Join(config.DataDir, "peer-controls.json"))
+	if err != nil {
+		panic(err)
+	}
+
+	inbox, err = NewInbox(filepath.Join(config.DataDir, "inbox.jsonl"))
+	if err != nil {
+		panic(err)
+	}
+
+	outbox, err = NewOutbox(filepath.Join(config.DataDir, "outbox.jsonl"), deliverToClientApi)
+	if err != nil {
+		panic(err)
+	}
+	go outbox.Run()
+
+	expertiseNotifier = NewExpertiseNotifier(config.NotifyDebounce)
+	go evictStaleExpertise(config.ExpertiseTTL)
+
+	embedder = newEmbedder(config)
+
+	backend, err := newSearchBackend(config)
+	if err != nil {
+		panic(err)
+	}
+	backendBreaker = NewCircuitBreaker(config.BreakerThreshold, announceAvailability)
+	searchBackend = NewBreakerSearchBackend(backend, backendBreaker)
+	go probeBackendHealth(searchBackend, config.HealthInterval)
+
+	go startWebApi(config)
+	if config.GrpcListen != "" {
+		go startGrpcApi(config)
+	}
 
 	// libp2p.New constructs a new libp2p Host. Other options can be added
 	// here.
@@ -507,13 +1080,18 @@ func main() {
 		panic(err)
 	}
 
-	clientApiUrl = strings.TrimRight(config.ClientApiUrl, "/")
+	connManager, err := newConnManager(config)
+	if err != nil {
+		panic(err)
+	}
 
 	opts := []libp2p.Option{
 		libp2p.NATPortMap(),
 		libp2p.EnableHolePunching(),
 		libp2p.ListenAddrs([]multiaddr.Multiaddr(config.ListenAddresses)...),
 		libp2p.Identity(privateKey),
+		libp2p.ConnectionGater(peerControls),
+		libp2p.ConnectionManager(connManager),
 	}
 
 	host, err := libp2p.New(opts...)
@@ -525,14 +1103,24 @@ func main() {
 
 	// Store the host in the global variable
 	globalHost = host
-	peerManager := NewPeerManager()
 
-	// Set a function as stream handler. This function is called when a peer
-	// initiates a connection and starts a stream with this peer.
-	host.SetStreamHandler(protocol.ID(config.ProtocolID), handleStream)
+	// Follow connections and identify results from here on
+	publishPeerEvents(peerManager)
+	if err := peerManager.Start(host); err != nil {
+		logger.Error("❌ Failed to follow peer events: ", err)
+		os.Exit(1)
+	}
 
-	// Set up the query protocol handler
+	// Set up the query protocol handlers
 	setupQueryProtocol(host)
+	setupBatchQueryProtocol(host)
+	setupAnswerProtocol(host)
+	setupFeedbackProtocol(host)
+	setupMessageProtocol(host)
+	trackReachability(host)
+	peerControls.Restore(host)
+	go keepPartners(host, config.Partners)
+	go tagPeersByExpertise(host)
 
 	// Start a DHT, for use in peer discovery. We can't just make a new DHT
 	// client because we want each peer to maintain its own local copy of the
@@ -544,7 +1132,7 @@ func main() {
 		peerinfo, _ := peer.AddrInfoFromP2pAddr(addr)
 		bootstrapPeers[i] = *peerinfo
 	}
-	kademliaDHT, err := dht.New(ctx, host, dht.BootstrapPeers(bootstrapPeers...))
+	kademliaDHT, err = dht.New(ctx, host, dht.BootstrapPeers(bootstrapPeers...))
 	if err != nil {
 		panic(err)
 	}
@@ -565,13 +1153,13 @@ func main() {
 	routingDiscovery := drouting.NewRoutingDiscovery(kademliaDHT)
 	dutil.Advertise(ctx, routingDiscovery, config.RendezvousString)
 
-	ps, err := pubsub.NewGossipSub(ctx, host)
+	ps, err := pubsub.NewGossipSub(ctx, host, pubsub.WithRawTracer(gossipMesh))
 	if err != nil {
 		panic(err)
 	}
 
 	// Initialize the global topic
-	topic, err = ps.Join("/rag-topics")
+	topic, err = ps.Join(gossipTopicName)
 	if err != nil {
 		panic(err)
 	}
@@ -609,24 +1197,12 @@ func main() {
 			// We do not want to connect again to the same peer
 			if host.Network().Connectedness(peer.ID) != network.Connected {
 				logger.Info("Connecting to: ", peer.ID, peer.Addrs)
-				stream, err := host.NewStream(ctx, peer.ID, protocol.ID(config.ProtocolID))
-
-				if err != nil {
+				if err := host.Connect(ctx, peer); err != nil {
 					logger.Warn("Connection failed: ", err)
-					peerManager.RemovePeer(peer.ID)
-					logger.Warn("Connection failed, peer ", peer.ID, " was removed.")
 					continue
-				} else {
-					rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-					peerManager.AddPeer(peer.ID, stream)
-					go writeData(rw, peer.ID)
-					go readData(rw, peer.ID)
 				}
 
 				logger.Info("*** 🥳 Connected to: ", peer)
-			} else {
-				peerManager.RemovePeer(peer.ID)
-				logger.Warn("Connection failed, peer ", peer.ID, " was removed.")
 			}
 
 			logger.Warn("No more peers 😢- Trying again")
@@ -637,18 +1213,6 @@ func main() {
 
 }
 
-func handleStream(stream network.Stream) {
-	logger.Info("*** 🥳 Got a new stream!")
-
-	// Create a buffer stream for non-blocking read and write.
-	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-
-	go readData(rw, "")
-	go writeData(rw, "")
-
-	// 'stream' will stay open until you close it (or the other side closes it).
-}
-
 // 🟢 Function to send our known topics to the gossip network
 func gossipTopics(pubsubTopic *pubsub.Topic) {
 	myExpertiseMutex.RLock()
@@ -662,9 +1226,13 @@ func gossipTopics(pubsubTopic *pubsub.Topic) {
 	for _, embeddingData := range myExpertise {
 		// Create payload
 		topicPayload := struct {
-			Data Expertise `json:"data"`
+			Data         Expertise         `json:"data"`
+			Capabilities QueryCapabilities `json:"capabilities"`
+			Available    bool              `json:"available"`
 		}{
-			Data: embeddingData,
+			Data:         embeddingData,
+			Capabilities: localQueryCapabilities(),
+			Available:    localAvailability(),
 		}
 
 		jsonData, err := json.Marshal(topicPayload)
@@ -687,18 +1255,25 @@ func gossipTopics(pubsubTopic *pubsub.Topic) {
 func listenForGossip(sub *pubsub.Subscription) {
 	for {
 		msg, err := sub.Next(context.Background())
+		if errors.Is(err, pubsub.ErrSubscriptionCancelled) {
+			return
+		}
 		if err != nil {
 			logger.Warn("❌ Error receiving gossip:", err)
 			continue
 		}
-		logger.Info("📩 Received gossip from: ", msg.ReceivedFrom)
+		logger.Info("📩 Received gossip from: ", msg.GetFrom())
 
-		// Get peer ID who sent this message
-		senderId := msg.ReceivedFrom.String()
+		// Get peer ID who wrote this message, not the neighbour relaying it
+		sender := msg.GetFrom()
+		senderId := sender.String()
 
 		// Parse the received JSON data
 		var expertisePayload struct {
-			Data Expertise `json:"data"`
+			Data         Expertise          `json:"data"`
+			Capabilities *QueryCapabilities `json:"capabilities"`
+			// Missing in announcements of older nodes
+			Available *bool `json:"available"`
 		}
 
 		if err := json.Unmarshal(msg.Data, &expertisePayload); err != nil {
@@ -706,53 +1281,18 @@ func listenForGossip(sub *pubsub.Subscription) {
 			continue
 		}
 
-		topicData := expertisePayload.Data
-
-		notifyExternalApiAboutGossipedTopic(topicData, senderId)
-	}
-}
-
-func readData(rw *bufio.ReadWriter, peerId peer.ID) {
-	for {
-		str, err := rw.ReadString('\n')
-		if err != nil {
-			fmt.Println("Error reading from buffer")
-			peerManager.RemovePeer(peerId)
-			break
-		}
-
-		if str == "" {
-			return
-		}
-		if str != "\n" {
-			// Green console colour: 	\x1b[32m
-			// Reset console colour: 	\x1b[0m
-			fmt.Printf("\x1b[32m%s\x1b[0m> ", str)
-		}
-
-	}
-}
-
-func writeData(rw *bufio.ReadWriter, perId peer.ID) {
-	stdReader := bufio.NewReader(os.Stdin)
-
-	for {
-		fmt.Print("> ")
-		sendData, err := stdReader.ReadString('\n')
-		if err != nil {
-			fmt.Println("Error reading from stdin")
-			panic(err)
-		}
+		// Peers re-gossip everything periodically, so only changes are reported
+		added, changed := knownExpertise.Update(sender, expertisePayload.Data, expertisePayload.Capabilities)
+		events.Publish(eventExpertiseReceived, senderId, gin.H{
+			"keys":    embeddingKeys(expertisePayload.Data.Embeddings),
+			"added":   len(added),
+			"changed": len(changed),
+		})
+		expertiseNotifier.Record(sender, added, changed, nil, expertisePayload.Capabilities)
 
-		_, err = rw.WriteString(fmt.Sprintf("%s\n", sendData))
-		if err != nil {
-			fmt.Println("Error writing to buffer")
-			peerManager.RemovePeer(perId)
-		}
-		err = rw.Flush()
-		if err != nil {
-			fmt.Println("Error flushing buffer")
-			peerManager.RemovePeer(perId)
+		if expertisePayload.Available != nil && knownExpertise.SetAvailable(sender, *expertisePayload.Available) {
+			logger.Info("🚦 Peer ", sender, " is now available: ", *expertisePayload.Available)
+			notifyExternalApiAboutAvailability(senderId, *expertisePayload.Available)
 		}
 	}
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// newTestHosts returns two connected in-memory hosts; the second one is
+// the local node that signs results
+func newTestHosts(t *testing.T) (host.Host, host.Host) {
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	remote, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := globalHost
+	globalHost = local
+	t.Cleanup(func() { globalHost = previous })
+	return remote, local
+}
+
+// useQueryAudit records audited queries in a fresh log for the rest of the test
+func useQueryAudit(t *testing.T) *QueryAuditLog {
+	previous := queryAudit
+	queryAudit = NewQueryAuditLog(filepath.Join(t.TempDir(), "queries.jsonl"), 0)
+	t.Cleanup(func() { queryAudit = previous })
+	return queryAudit
+}
+
+// auditEntries returns every audit entry, newest first
+func auditEntries(t *testing.T, ql *QueryAuditLog) []QueryAuditEntry {
+	entries, _, err := ql.Find(QueryAuditFilter{}, 0, maxPageLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// exchange sends request on a new stream and decodes the answer into response
+func exchange(t *testing.T, from host.Host, to host.Host, id string, request interface{}, response interface{}) network.Stream {
+	stream, err := from.NewStream(context.Background(), to.ID(), protocol.ID(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stream.Close() })
+	if err := json.NewEncoder(stream).Encode(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(stream).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+func TestHandleQueryStream(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupQueryProtocol(local)
+	audit := useQueryAudit(t)
+	backend := &fakeSearchBackend{result: memoryResult("a", "b")}
+	useSearchBackend(t, backend)
+
+	request := QueryRequest{QueryId: "q1", ExpertiseKey: "go", MatchCount: 2, Vector: Vector{1}}
+	var response QueryResponse
+	stream := exchange(t, remote, local, queryProtocolID, request, &response)
+
+	if !response.Success {
+		t.Fatalf("query failed: %s", response.Error)
+	}
+	if response.Signer != local.ID().String() {
+		t.Errorf("signer = %s, want %s", response.Signer, local.ID())
+	}
+	if err := verifyQueryResult(stream.Conn().RemotePublicKey(), request, response.Result, response.Signature); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+	if got := backend.Queries(); len(got) != 1 || got[0].QueryId != "q1" {
+		t.Errorf("backend received %+v", got)
+	}
+
+	stream.Close()
+	entries := waitForAudit(t, audit, 1)
+	entry := entries[0]
+	if entry.Direction != queryInbound || entry.PeerId != remote.ID().String() || entry.ErrorCode != "" {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+	if entry.ResultCount != 2 {
+		t.Errorf("result_count = %d, want 2", entry.ResultCount)
+	}
+}
+
+func TestHandleQueryStreamErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		request   QueryRequest
+		err       error
+		errorCode string
+		queried   bool
+	}{
+		{
+			name:      "backend error",
+			request:   QueryRequest{QueryId: "q1"},
+			err:       errors.New("connection refused"),
+			errorCode: queryErrBackend,
+			queried:   true,
+		},
+		{
+			name:      "unsupported filter",
+			request:   QueryRequest{QueryId: "q2", Filter: QueryFilter{"year": map[string]interface{}{"$gt": 2020}}},
+			errorCode: queryErrInvalidQuery,
+		},
+		{
+			name:      "text search not supported",
+			request:   QueryRequest{QueryId: "q3", QueryText: "libp2p"},
+			errorCode: queryErrInvalidQuery,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote, local := newTestHosts(t)
+			setupQueryProtocol(local)
+			audit := useQueryAudit(t)
+			backend := &fakeSearchBackend{err: test.err, capabilities: QueryCapabilities{FilterOperators: []string{"$eq"}}}
+			useSearchBackend(t, backend)
+
+			var response QueryResponse
+			exchange(t, remote, local, queryProtocolID, test.request, &response).Close()
+
+			if response.Success || response.Error == "" {
+				t.Errorf("expected an error response, got %+v", response)
+			}
+			if queried := len(backend.Queries()) > 0; queried != test.queried {
+				t.Errorf("backend queried = %v, want %v", queried, test.queried)
+			}
+			if entry := waitForAudit(t, audit, 1)[0]; entry.ErrorCode != test.errorCode {
+				t.Errorf("error_code = %q, want %q", entry.ErrorCode, test.errorCode)
+			}
+		})
+	}
+}
+
+// waitForAudit waits until the handler, which audits after it replied, has
+// recorded count entries
+func waitForAudit(t *testing.T, ql *QueryAuditLog, count int) []QueryAuditEntry {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entries := auditEntries(t, ql)
+		if len(entries) >= count {
+			return entries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d audit entries, want %d", len(entries), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenForGossipCreditsAuthor(t *testing.T) {
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+
+	// a only reaches c through b
+	hosts := make([]host.Host, 3)
+	for i := range hosts {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts[i] = h
+	}
+	a, b, c := hosts[0], hosts[1], hosts[2]
+	for _, pair := range [][2]host.Host{{a, b}, {b, c}} {
+		if _, err := mn.LinkPeers(pair[0].ID(), pair[1].ID()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mn.ConnectPeers(pair[0].ID(), pair[1].ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previousExpertise, previousNotifier := knownExpertise, expertiseNotifier
+	knownExpertise = NewExpertiseRegistry()
+	expertiseNotifier = NewExpertiseNotifier(time.Hour)
+	t.Cleanup(func() { knownExpertise, expertiseNotifier = previousExpertise, previousNotifier })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	topics := make([]*pubsub.Topic, len(hosts))
+	for i, h := range hosts {
+		ps, err := pubsub.NewGossipSub(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if topics[i], err = ps.Join(gossipTopicName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	relay, err := topics[1].Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(relay.Cancel)
+	sub, err := topics[2].Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		listenForGossip(sub)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		sub.Cancel()
+		<-done
+	})
+
+	payload := map[string]interface{}{
+		"data":      Expertise{Embeddings: []Embedding{{Key: "go", Expertise: "Go", Model: "test", Vector: []float64{1, 0}}}},
+		"available": true,
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The mesh takes a few heartbeats to form, so keep announcing until c hears it
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if err := topics[0].Publish(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, ok := knownExpertise.Get(a.ID()); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("c never received the announcement")
+		}
+	}
+	if _, ok := knownExpertise.Get(b.ID()); ok {
+		t.Error("relay b was credited with a's expertise")
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Connection manager tag of peers protected through the API
+const protectTag = "p2p-rag-admin"
+
+// Timeout for dialing peers restored from disk
+const restoreDialTimeout = 30 * time.Second
+
+// PeerControls is the connectivity set by hand through the admin API: peers
+// to stay connected to, banned peers and peers protected from pruning. It
+// gates connections of the host and is persisted so it survives restarts.
+type PeerControls struct {
+	state peerControlsState
+	path  string
+	mutex sync.Mutex
+}
+
+type peerControlsState struct {
+	// Addresses connected to through the API, redialed on start
+	Static map[peer.ID][]string `json:"static"`
+	// Banned peers and when their ban ends
+	Bans      map[peer.ID]time.Time `json:"bans"`
+	Protected map[peer.ID]bool      `json:"protected"`
+}
+
+// The connectivity set through the admin API, loaded in main
+var peerControls = newPeerControls("")
+
+func newPeerControls(path string) *PeerControls {
+	return &PeerControls{
+		state: peerControlsState{
+			Static:    make(map[peer.ID][]string),
+			Bans:      make(map[peer.ID]time.Time),
+			Protected: make(map[peer.ID]bool),
+		},
+		path: path,
+	}
+}
+
+// NewPeerControls loads the controls stored at path, if any
+func NewPeerControls(path string) (*PeerControls, error) {
+	pc := newPeerControls(path)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return pc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pc.state); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// persist writes the controls to disk; callers must hold the mutex
+func (pc *PeerControls) persist() {
+	if pc.path == "" {
+		return
+	}
+	data, err := json.Marshal(pc.state)
+	if err == nil {
+		err = writeFileAtomic(pc.path, data)
+	}
+	if err != nil {
+		logger.Warn("❌ Error saving peer controls:", err)
+	}
+}
+
+// AddStatic remembers an address to connect to on every start
+func (pc *PeerControls) AddStatic(p peer.ID, addr string) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	for _, known := range pc.state.Static[p] {
+		if known == addr {
+			return
+		}
+	}
+	pc.state.Static[p] = append(pc.state.Static[p], addr)
+	pc.persist()
+}
+
+// RemoveStatic forgets the addresses of a peer
+func (pc *PeerControls) RemoveStatic(p peer.ID) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if _, ok := pc.state.Static[p]; ok {
+		delete(pc.state.Static, p)
+		pc.persist()
+	}
+}
+
+// Ban refuses connections with a peer until the given time
+func (pc *PeerControls) Ban(p peer.ID, until time.Time) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.state.Bans[p] = until
+	pc.persist()
+}
+
+// Unban lifts the ban of a peer, returning whether it was banned
+func (pc *PeerControls) Unban(p peer.ID) bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if _, ok := pc.state.Bans[p]; !ok {
+		return false
+	}
+	delete(pc.state.Bans, p)
+	pc.persist()
+	return true
+}
+
+// BannedUntil returns when the ban of a peer ends, expired bans are dropped
+func (pc *PeerControls) BannedUntil(p peer.ID) (time.Time, bool) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	until, ok := pc.state.Bans[p]
+	if !ok {
+		return time.Time{}, false
+	}
+	if time.Now().After(until) {
+		delete(pc.state.Bans, p)
+		pc.persist()
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// SetProtected records whether a peer is protected from connection pruning
+func (pc *PeerControls) SetProtected(p peer.ID, protected bool) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if protected {
+		pc.state.Protected[p] = true
+	} else {
+		delete(pc.state.Protected, p)
+	}
+	pc.persist()
+}
+
+// Protected reports whether a peer is protected from connection pruning
+func (pc *PeerControls) Protected(p peer.ID) bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	return pc.state.Protected[p]
+}
+
+// Peers returns every peer with a control set
+func (pc *PeerControls) Peers() []peer.ID {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	ids := make(map[peer.ID]bool)
+	for p := range pc.state.Static {
+		ids[p] = true
+	}
+	for p := range pc.state.Bans {
+		ids[p] = true
+	}
+	for p := range pc.state.Protected {
+		ids[p] = true
+	}
+	peers := make([]peer.ID, 0, len(ids))
+	for p := range ids {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Restore protects and redials the peers stored on disk
+func (pc *PeerControls) Restore(h host.Host) {
+	pc.mutex.Lock()
+	protected := make([]peer.ID, 0, len(pc.state.Protected))
+	for p := range pc.state.Protected {
+		protected = append(protected, p)
+	}
+	static := make(map[peer.ID][]string, len(pc.state.Static))
+	for p, addrs := range pc.state.Static {
+		static[p] = addrs
+	}
+	pc.mutex.Unlock()
+
+	for _, p := range protected {
+		h.ConnManager().Protect(p, protectTag)
+	}
+	for p, addrs := range static {
+		info := peer.AddrInfo{ID: p}
+		for _, addr := range addrs {
+			ma, err := multiaddr.NewMultiaddr(addr)
+			if err != nil {
+				logger.Warn("❌ Invalid stored peer address ", addr, ":", err)
+				continue
+			}
+			info.Addrs = append(info.Addrs, ma)
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), restoreDialTimeout)
+			defer cancel()
+			if err := h.Connect(ctx, info); err != nil {
+				logger.Warn("❌ Failed to reconnect to stored peer ", info.ID, ":", err)
+				return
+			}
+			logger.Info("🔗 Reconnected to stored peer ", info.ID)
+		}()
+	}
+}
+
+// The methods below make PeerControls a connection gater refusing banned
+// peers. Only dials and secured connections know the remote peer.
+
+func (pc *PeerControls) InterceptPeerDial(p peer.ID) bool {
+	_, banned := pc.BannedUntil(p)
+	return !banned
+}
+
+func (pc *PeerControls) InterceptAddrDial(p peer.ID, addr multiaddr.Multiaddr) bool {
+	return pc.InterceptPeerDial(p)
+}
+
+func (pc *PeerControls) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (pc *PeerControls) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	return pc.InterceptPeerDial(p)
+}
+
+func (pc *PeerControls) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
@@ -1,62 +1,456 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
-	//"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
 )
 
-// PeerManager keeps track of connected peers and their streams
+// How long state of a disconnected peer is kept, and how often it is pruned
+const (
+	forgetPeerAfter    = 24 * time.Hour
+	forgetPeerInterval = time.Hour
+)
+
+// PeerState is what the host told us about a peer through connection and
+// identify events
+type PeerState struct {
+	Id             peer.ID
+	Connected      bool
+	Direction      network.Direction
+	ConnectedSince time.Time
+	FirstSeen      time.Time
+	LastSeen       time.Time
+	Addresses      []multiaddr.Multiaddr
+	Protocols      []protocol.ID
+	AgentVersion   string
+	Latency        time.Duration
+	Identified     bool
+}
+
+// PeerEvent is a peer lifecycle change: eventPeerConnected,
+// eventPeerDisconnected or eventPeerIdentified. Address is the remote
+// address of the connection that connected the peer.
+type PeerEvent struct {
+	Type    string
+	State   PeerState
+	Address multiaddr.Multiaddr
+}
+
+// PeerManager keeps the state of the peers we are or were connected to. It
+// is fed by the network notifications and identify events of the host and
+// passes every change on to its subscribers.
 type PeerManager struct {
-	peers map[peer.ID]network.Stream
-	mutex sync.Mutex
+	host        host.Host
+	peers       map[peer.ID]*PeerState
+	subscribers map[chan PeerEvent]bool
+	mutex       sync.Mutex
 }
 
-// NewPeerManager initializes the peer list
+// NewPeerManager creates a manager that knows no peers until started
 func NewPeerManager() *PeerManager {
 	return &PeerManager{
-		peers: make(map[peer.ID]network.Stream),
+		peers:       make(map[peer.ID]*PeerState),
+		subscribers: make(map[chan PeerEvent]bool),
+	}
+}
+
+// Start follows the connections and identify events of the host
+func (pm *PeerManager) Start(h host.Host) error {
+	sub, err := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtPeerProtocolsUpdated),
+	})
+	if err != nil {
+		return err
+	}
+
+	pm.mutex.Lock()
+	pm.host = h
+	pm.mutex.Unlock()
+
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF:    pm.connected,
+		DisconnectedF: pm.disconnected,
+	})
+	// Connections opened before the notifiee was registered
+	for _, conn := range h.Network().Conns() {
+		pm.connected(h.Network(), conn)
+	}
+
+	go func() {
+		defer sub.Close()
+		for e := range sub.Out() {
+			switch e := e.(type) {
+			case event.EvtPeerIdentificationCompleted:
+				pm.identified(e)
+			case event.EvtPeerProtocolsUpdated:
+				pm.protocolsUpdated(e)
+			}
+		}
+	}()
+	go func() {
+		for {
+			time.Sleep(forgetPeerInterval)
+			pm.forget(forgetPeerAfter)
+		}
+	}()
+	return nil
+}
+
+// Subscribe returns a channel receiving peer lifecycle changes and a
+// function to stop receiving them. Changes are dropped for subscribers
+// that fall behind.
+func (pm *PeerManager) Subscribe() (<-chan PeerEvent, func()) {
+	ch := make(chan PeerEvent, eventBufferSize)
+
+	pm.mutex.Lock()
+	pm.subscribers[ch] = true
+	pm.mutex.Unlock()
+
+	return ch, func() {
+		pm.mutex.Lock()
+		defer pm.mutex.Unlock()
+		delete(pm.subscribers, ch)
+	}
+}
+
+// publish passes a change to the subscribers; callers must hold the mutex
+func (pm *PeerManager) publish(change PeerEvent) {
+	for ch := range pm.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+func (s *PeerState) clone() PeerState {
+	c := *s
+	c.Addresses = slices.Clone(s.Addresses)
+	c.Protocols = slices.Clone(s.Protocols)
+	return c
+}
+
+// stateOf returns the state of a peer, creating it when missing; callers
+// must hold the mutex
+func (pm *PeerManager) stateOf(p peer.ID, now time.Time) *PeerState {
+	state, ok := pm.peers[p]
+	if !ok {
+		state = &PeerState{Id: p, FirstSeen: now}
+		pm.peers[p] = state
 	}
+	state.LastSeen = now
+	return state
 }
 
-// AddPeer registers a new peer connection
-func (pm *PeerManager) AddPeer(p peer.ID, stream network.Stream) {
+func (pm *PeerManager) connected(n network.Network, conn network.Conn) {
+	p := conn.RemotePeer()
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
-	pm.peers[p] = stream
-	fmt.Printf("Added peer: %s\n", p)
+
+	state := pm.stateOf(p, time.Now())
+	if !slices.ContainsFunc(state.Addresses, conn.RemoteMultiaddr().Equal) {
+		state.Addresses = append(state.Addresses, conn.RemoteMultiaddr())
+	}
+	if state.Connected {
+		return
+	}
+	state.Connected = true
+	state.Direction = conn.Stat().Direction
+	state.ConnectedSince = conn.Stat().Opened
+
+	pm.publish(PeerEvent{Type: eventPeerConnected, State: state.clone(), Address: conn.RemoteMultiaddr()})
 }
 
-// RemovePeer removes a peer from the list
-func (pm *PeerManager) RemovePeer(p peer.ID) {
+func (pm *PeerManager) disconnected(n network.Network, conn network.Conn) {
+	pm.closed(n, conn.RemotePeer())
+}
+
+// closed updates the state of a peer after a connection to it closed.
+// Closing connections ourselves calls it directly, since notifications
+// arrive asynchronously.
+func (pm *PeerManager) closed(n network.Network, p peer.ID) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
-	delete(pm.peers, p)
-	fmt.Printf("Removed peer: %s\n", p)
+
+	state := pm.stateOf(p, time.Now())
+
+	// Another connection is still open, it now tells since when we are connected
+	if conns := n.ConnsToPeer(p); len(conns) > 0 {
+		state.ConnectedSince = time.Time{}
+		for _, c := range conns {
+			if state.ConnectedSince.IsZero() || c.Stat().Opened.Before(state.ConnectedSince) {
+				state.ConnectedSince = c.Stat().Opened
+				state.Direction = c.Stat().Direction
+			}
+		}
+		return
+	}
+	if !state.Connected {
+		return
+	}
+	state.Connected = false
+	state.Direction = network.DirUnknown
+	state.ConnectedSince = time.Time{}
+
+	pm.publish(PeerEvent{Type: eventPeerDisconnected, State: state.clone()})
 }
 
-// ListPeers returns all connected peers
-func (pm *PeerManager) ListPeers() map[peer.ID]network.Stream {
+func (pm *PeerManager) identified(e event.EvtPeerIdentificationCompleted) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
-	return pm.peers
+
+	state := pm.stateOf(e.Peer, time.Now())
+	if len(e.ListenAddrs) > 0 {
+		state.Addresses = slices.Clone(e.ListenAddrs)
+	}
+	state.Protocols = slices.Clone(e.Protocols)
+	slices.Sort(state.Protocols)
+	state.AgentVersion = e.AgentVersion
+	state.Identified = true
+
+	pm.publish(PeerEvent{Type: eventPeerIdentified, State: state.clone()})
 }
 
-/* // Integrate with the host
-func handleNewStream(pm *PeerManager) network.StreamHandler {
-	return func(s network.Stream) {
-		pm.AddPeer(s.Conn().RemotePeer(), s)
+func (pm *PeerManager) protocolsUpdated(e event.EvtPeerProtocolsUpdated) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	state, ok := pm.peers[e.Peer]
+	if !ok {
+		return
+	}
+	state.Protocols = slices.DeleteFunc(state.Protocols, func(proto protocol.ID) bool {
+		return slices.Contains(e.Removed, proto)
+	})
+	for _, proto := range e.Added {
+		if !slices.Contains(state.Protocols, proto) {
+			state.Protocols = append(state.Protocols, proto)
+		}
 	}
+	slices.Sort(state.Protocols)
 }
 
-func main() {
-	// Initialize Peer Manager
-	peerManager := NewPeerManager()
+// forget drops disconnected peers not seen for the given time
+func (pm *PeerManager) forget(after time.Duration) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
 
-	// Example: Initialize host and set stream handler (Replace 'h' with your host instance)
-	var h host.Host // Assume this is properly initialized
-	h.SetStreamHandler("/p2p-rag/1.0.0", handleNewStream(peerManager))
-} */
+	cutoff := time.Now().Add(-after)
+	for p, state := range pm.peers {
+		if !state.Connected && state.LastSeen.Before(cutoff) {
+			delete(pm.peers, p)
+		}
+	}
+}
+
+// State returns what we know about a peer, with its current latency
+func (pm *PeerManager) State(p peer.ID) (PeerState, bool) {
+	pm.mutex.Lock()
+	state, ok := pm.peers[p]
+	if !ok {
+		pm.mutex.Unlock()
+		return PeerState{}, false
+	}
+	result := state.clone()
+	h := pm.host
+	pm.mutex.Unlock()
+
+	if result.Connected {
+		result.LastSeen = time.Now()
+	}
+	if h != nil {
+		result.Latency = h.Peerstore().LatencyEWMA(p)
+	}
+	return result, true
+}
+
+// PeerIds returns every peer with a known state
+func (pm *PeerManager) PeerIds() []peer.ID {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	ids := make([]peer.ID, 0, len(pm.peers))
+	for p := range pm.peers {
+		ids = append(ids, p)
+	}
+	return ids
+}
+
+// PeerInfo is what we know about a connected peer or a peer that gossiped
+// expertise to us
+type PeerInfo struct {
+	Id             string    `json:"nodeId"`
+	Connected      bool      `json:"connected"`
+	Direction      string    `json:"direction,omitempty"`
+	ConnectedSince time.Time `json:"connected_since,omitzero"`
+	Addresses      []string  `json:"addresses"`
+	Protocols      []string  `json:"protocols"`
+	AgentVersion   string    `json:"agent_version,omitempty"`
+	LatencyMs      float64   `json:"latency_ms,omitempty"`
+	MeshTopics     []string  `json:"mesh_topics"`
+	Relevance      int       `json:"relevance"`
+	Reputation     float64   `json:"reputation"`
+	Available      bool      `json:"available"`
+	ExpertiseKeys  []string  `json:"expertise_keys"`
+	FirstSeen      time.Time `json:"first_seen,omitzero"`
+	LastSeen       time.Time `json:"last_seen,omitzero"`
+	Protected      bool      `json:"protected"`
+	BannedUntil    time.Time `json:"banned_until,omitzero"`
+}
+
+// Peers describes the peers we are or were connected to, know the
+// expertise of or set a control on
+func (pm *PeerManager) Peers(h host.Host) []PeerInfo {
+	ids := make(map[peer.ID]bool)
+	for _, p := range pm.PeerIds() {
+		ids[p] = true
+	}
+	for _, p := range knownExpertise.Peers() {
+		ids[p] = true
+	}
+	for _, p := range peerControls.Peers() {
+		ids[p] = true
+	}
+
+	peers := make([]PeerInfo, 0, len(ids))
+	for p := range ids {
+		peers = append(peers, pm.Describe(h, p))
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Id < peers[j].Id })
+	return peers
+}
+
+// Known reports whether we have state of a peer, know its expertise, have
+// addresses of it or set a control on it
+func (pm *PeerManager) Known(h host.Host, p peer.ID) bool {
+	if _, ok := pm.State(p); ok {
+		return true
+	}
+	if _, ok := knownExpertise.Get(p); ok {
+		return true
+	}
+	if _, banned := peerControls.BannedUntil(p); banned || peerControls.Protected(p) {
+		return true
+	}
+	return h != nil && len(h.Peerstore().Addrs(p)) > 0
+}
+
+// Describe collects what the peer state, the gossip mesh and the expertise
+// registry know about a peer
+func (pm *PeerManager) Describe(h host.Host, p peer.ID) PeerInfo {
+	info := PeerInfo{
+		Id:            p.String(),
+		Addresses:     []string{},
+		Protocols:     []string{},
+		MeshTopics:    gossipMesh.Topics(p),
+		Reputation:    reputation.Score(p),
+		Available:     knownExpertise.Available(p),
+		ExpertiseKeys: []string{},
+		Protected:     peerControls.Protected(p),
+	}
+	info.BannedUntil, _ = peerControls.BannedUntil(p)
+
+	if state, ok := pm.State(p); ok {
+		info.Connected = state.Connected
+		if state.Connected {
+			info.Direction = state.Direction.String()
+			info.ConnectedSince = state.ConnectedSince
+		}
+		for _, addr := range state.Addresses {
+			info.Addresses = append(info.Addresses, addr.String())
+		}
+		for _, proto := range state.Protocols {
+			info.Protocols = append(info.Protocols, string(proto))
+		}
+		info.AgentVersion = state.AgentVersion
+		if state.Latency > 0 {
+			info.LatencyMs = float64(state.Latency.Microseconds()) / 1000
+		}
+		info.FirstSeen = state.FirstSeen
+		info.LastSeen = state.LastSeen
+	} else if h != nil {
+		// Peers we never connected to may still have addresses, e.g. from the DHT
+		for _, addr := range h.Peerstore().Addrs(p) {
+			info.Addresses = append(info.Addresses, addr.String())
+		}
+	}
+	if h != nil {
+		if tags := h.ConnManager().GetTagInfo(p); tags != nil {
+			info.Relevance = tags.Tags[expertiseTag]
+		}
+	}
+
+	if known, ok := knownExpertise.Get(p); ok {
+		for key := range known.Embeddings {
+			info.ExpertiseKeys = append(info.ExpertiseKeys, key)
+		}
+		sort.Strings(info.ExpertiseKeys)
+		if known.LastSeen.After(info.LastSeen) {
+			info.LastSeen = known.LastSeen
+		}
+	}
+	return info
+}
+
+// Connect dials a multiaddr ending in /p2p/<peer ID> and remembers it, so
+// the node connects to it again after a restart
+func (pm *PeerManager) Connect(ctx context.Context, h host.Host, addr multiaddr.Multiaddr) (peer.ID, error) {
+	info, err := peer.AddrInfoFromP2pAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	if err := h.Connect(ctx, *info); err != nil {
+		return info.ID, err
+	}
+	peerControls.AddStatic(info.ID, addr.String())
+	logger.Info("🔗 Connected to ", info.ID, " on request")
+	return info.ID, nil
+}
+
+// Disconnect closes the connections to a peer and forgets its remembered
+// addresses. Discovery may connect to it again; ban it to prevent that.
+func (pm *PeerManager) Disconnect(h host.Host, p peer.ID) error {
+	peerControls.RemoveStatic(p)
+	err := h.Network().ClosePeer(p)
+	pm.closed(h.Network(), p)
+	return err
+}
+
+// Ban refuses connections with a peer for the given duration and closes
+// the open ones
+func (pm *PeerManager) Ban(h host.Host, p peer.ID, duration time.Duration) (time.Time, error) {
+	until := time.Now().Add(duration)
+	peerControls.Ban(p, until)
+	logger.Info("🚫 Banned ", p, " until ", until.Format(time.RFC3339))
+	err := h.Network().ClosePeer(p)
+	pm.closed(h.Network(), p)
+	return until, err
+}
+
+// Unban lets a banned peer connect again, returning whether it was banned
+func (pm *PeerManager) Unban(p peer.ID) bool {
+	return peerControls.Unban(p)
+}
+
+// Protect keeps the connection manager from pruning connections to a peer
+func (pm *PeerManager) Protect(h host.Host, p peer.ID) {
+	peerControls.SetProtected(p, true)
+	h.ConnManager().Protect(p, protectTag)
+}
+
+// Unprotect lets the connection manager prune connections to a peer again
+func (pm *PeerManager) Unprotect(h host.Host, p peer.ID) {
+	peerControls.SetProtected(p, false)
+	h.ConnManager().Unprotect(p, protectTag)
+}
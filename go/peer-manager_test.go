@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// nextEvent waits for the next node event
+func nextEvent(t *testing.T, ch <-chan NodeEvent) NodeEvent {
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event published")
+		return NodeEvent{}
+	}
+}
+
+func TestPeerManagerPublishesNodeEvents(t *testing.T) {
+	remote, local := newTestHosts(t)
+	received, unsubscribe := events.Subscribe([]string{eventPeerConnected, eventPeerDisconnected})
+	defer unsubscribe()
+
+	pm := NewPeerManager()
+	publishPeerEvents(pm)
+	if err := pm.Start(local); err != nil {
+		t.Fatal(err)
+	}
+
+	event := nextEvent(t, received)
+	if event.Type != eventPeerConnected || event.PeerId != remote.ID().String() {
+		t.Fatalf("got %+v, want %s for %s", event, eventPeerConnected, remote.ID())
+	}
+	if data, _ := event.Data.(map[string]interface{}); data["address"] == "" {
+		t.Errorf("connected event without address: %+v", event)
+	}
+	if state, ok := pm.State(remote.ID()); !ok || !state.Connected {
+		t.Errorf("remote peer not tracked as connected: %+v", state)
+	}
+
+	if err := pm.Disconnect(local, remote.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, received); event.Type != eventPeerDisconnected || event.PeerId != remote.ID().String() {
+		t.Errorf("got %+v, want %s for %s", event, eventPeerDisconnected, remote.ID())
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How often entries older than the retention are removed
+const queryAuditCompactInterval = time.Hour
+
+// Page size of paginated lists when no limit is given, and its maximum
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// Direction of an audited query
+const (
+	queryInbound  = "inbound"
+	queryOutbound = "outbound"
+	queryLocal    = "local"
+)
+
+// Error codes of failed queries in the audit log
+const (
+	queryErrBadRequest        = "bad_request"
+	queryErrInvalidQuery      = "invalid_query"
+	queryErrBackend           = "backend_error"
+	queryErrSigning           = "signing_failed"
+	queryErrSend              = "send_failed"
+	queryErrNotConnected      = "not_connected"
+	queryErrStream            = "stream_failed"
+	queryErrMalformedResponse = "malformed_response"
+	queryErrRemote            = "remote_error"
+	queryErrBadSignature      = "bad_signature"
+	queryErrCancelled         = "cancelled"
+)
+
+// QueryAuditEntry records one query sent, served or run locally
+type QueryAuditEntry struct {
+	Time         time.Time `json:"time"`
+	Direction    string    `json:"direction"`
+	QueryId      string    `json:"queryId"`
+	PeerId       string    `json:"nodeId"`
+	ExpertiseKey string    `json:"expertise_key"`
+	MatchCount   int       `json:"match_count"`
+	LatencyMs    int64     `json:"latency_ms"`
+	ResultCount  int       `json:"result_count"`
+	ErrorCode    string    `json:"error_code,omitempty"`
+}
+
+// QueryAuditFilter selects audit entries; empty fields match everything.
+// ErrorCode also accepts "any" for failed and "none" for successful queries.
+type QueryAuditFilter struct {
+	Direction    string
+	PeerId       string
+	QueryId      string
+	ExpertiseKey string
+	ErrorCode    string
+	Since        time.Time
+	Until        time.Time
+}
+
+func (f QueryAuditFilter) matches(entry QueryAuditEntry) bool {
+	switch {
+	case f.Direction != "" && entry.Direction != f.Direction,
+		f.PeerId != "" && entry.PeerId != f.PeerId,
+		f.QueryId != "" && entry.QueryId != f.QueryId,
+		f.ExpertiseKey != "" && entry.ExpertiseKey != f.ExpertiseKey,
+		!f.Since.IsZero() && entry.Time.Before(f.Since),
+		!f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	}
+	switch f.ErrorCode {
+	case "":
+		return true
+	case "any":
+		return entry.ErrorCode != ""
+	case "none":
+		return entry.ErrorCode == ""
+	default:
+		return entry.ErrorCode == f.ErrorCode
+	}
+}
+
+// QueryAuditLog appends query entries to a JSON lines file. Entries older
+// than the retention are removed periodically by rewriting the file.
+type QueryAuditLog struct {
+	path      string
+	retention time.Duration
+	// Entries by query ID, built from the file on the first lookup
+	byQuery map[string][]QueryAuditEntry
+	mutex   sync.Mutex
+}
+
+// The audit log of queries, opened in main
+var queryAudit *QueryAuditLog
+
+// NewQueryAuditLog opens the audit log at path, keeping entries for
+// retention or forever when it is 0
+func NewQueryAuditLog(path string, retention time.Duration) *QueryAuditLog {
+	return &QueryAuditLog{path: path, retention: retention}
+}
+
+// Record appends entries; failures are logged since auditing must never
+// fail a query
+func (ql *QueryAuditLog) Record(entries ...QueryAuditEntry) {
+	if ql == nil || len(entries) == 0 {
+		return
+	}
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			logger.Warn("❌ Error encoding query audit entry:", err)
+			return
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	file, err := os.OpenFile(ql.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		logger.Warn("❌ Error opening query audit log:", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		logger.Warn("❌ Error writing query audit log:", err)
+		return
+	}
+	if ql.byQuery != nil {
+		ql.index(entries)
+	}
+}
+
+// index adds entries to the query ID index; callers must hold the mutex
+func (ql *QueryAuditLog) index(entries []QueryAuditEntry) {
+	for _, entry := range entries {
+		ql.byQuery[entry.QueryId] = append(ql.byQuery[entry.QueryId], entry)
+	}
+}
+
+// Query returns the entries of a query, oldest first, without reading the
+// whole file once the index is built
+func (ql *QueryAuditLog) Query(queryId string) ([]QueryAuditEntry, error) {
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	if ql.byQuery == nil {
+		entries, err := ql.read()
+		if err != nil {
+			return nil, err
+		}
+		ql.byQuery = make(map[string][]QueryAuditEntry)
+		ql.index(entries)
+	}
+	return slices.Clone(ql.byQuery[queryId]), nil
+}
+
+// read returns every entry in the file; callers must hold the mutex
+func (ql *QueryAuditLog) read() ([]QueryAuditEntry, error) {
+	file, err := os.Open(ql.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []QueryAuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry QueryAuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Warn("❌ Skipping malformed query audit entry:", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Find returns a page of the matching entries, newest first, and how many
+// entries match in total
+func (ql *QueryAuditLog) Find(filter QueryAuditFilter, offset int, limit int) ([]QueryAuditEntry, int, error) {
+	ql.mutex.Lock()
+	entries, err := ql.read()
+	ql.mutex.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	matching := []QueryAuditEntry{}
+	for _, entry := range slices.Backward(entries) {
+		if filter.matches(entry) {
+			matching = append(matching, entry)
+		}
+	}
+	total := len(matching)
+	if offset >= total {
+		return []QueryAuditEntry{}, total, nil
+	}
+	return matching[offset:min(offset+limit, total)], total, nil
+}
+
+// Compact removes the entries older than the retention
+func (ql *QueryAuditLog) Compact() error {
+	if ql.retention == 0 {
+		return nil
+	}
+
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	entries, err := ql.read()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-ql.retention)
+	kept := slices.DeleteFunc(slices.Clone(entries), func(entry QueryAuditEntry) bool {
+		return entry.Time.Before(cutoff)
+	})
+	if len(kept) == len(entries) {
+		return nil
+	}
+
+	var data []byte
+	for _, entry := range kept {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := writeFileAtomic(ql.path, data); err != nil {
+		return err
+	}
+	if ql.byQuery != nil {
+		ql.byQuery = make(map[string][]QueryAuditEntry)
+		ql.index(kept)
+	}
+	logger.Info("🧹 Removed ", len(entries)-len(kept), " query audit entries older than ", ql.retention)
+	return nil
+}
+
+// compactQueryAuditPeriodically applies the retention in the background
+func compactQueryAuditPeriodically(ql *QueryAuditLog) {
+	for {
+		if err := ql.Compact(); err != nil {
+			logger.Warn("❌ Error compacting query audit log:", err)
+		}
+		time.Sleep(queryAuditCompactInterval)
+	}
+}
+
+// parseQueryAuditParams reads the filter and page of a GET /queries request
+func parseQueryAuditParams(query func(string) string) (QueryAuditFilter, int, int, error) {
+	filter := QueryAuditFilter{
+		Direction:    query("direction"),
+		PeerId:       query("nodeId"),
+		QueryId:      query("queryId"),
+		ExpertiseKey: query("expertise_key"),
+		ErrorCode:    query("error_code"),
+	}
+	switch filter.Direction {
+	case "", queryInbound, queryOutbound, queryLocal:
+	default:
+		return filter, 0, 0, fmt.Errorf("direction must be %s, %s or %s", queryInbound, queryOutbound, queryLocal)
+	}
+
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, 0, 0, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*target = t
+		}
+	}
+
+	offset, limit, err := parsePage(query)
+	return filter, offset, limit, err
+}
+
+// parsePage reads the offset and limit parameters of a paginated list
+func parsePage(query func(string) string) (int, int, error) {
+	offset, limit := 0, defaultPageLimit
+	if value := query("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	if value := query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		limit = n
+	}
+	return offset, limit, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueryAuditLogQuery(t *testing.T) {
+	ql := NewQueryAuditLog(filepath.Join(t.TempDir(), "queries.jsonl"), time.Hour)
+	ql.Record(
+		QueryAuditEntry{Time: time.Now().Add(-2 * time.Hour), QueryId: "old"},
+		QueryAuditEntry{Time: time.Now(), QueryId: "q1", PeerId: "a"},
+	)
+
+	// The first lookup builds the index from the file
+	entries, err := ql.Query("q1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].PeerId != "a" {
+		t.Fatalf("entries = %+v", entries)
+	}
+
+	// Later entries are added to the index as they are recorded
+	ql.Record(QueryAuditEntry{Time: time.Now(), QueryId: "q1", PeerId: "b"})
+	if entries, _ := ql.Query("q1"); len(entries) != 2 || entries[1].PeerId != "b" {
+		t.Errorf("entries after record = %+v", entries)
+	}
+
+	// Compaction drops expired entries from the index too
+	if entries, _ := ql.Query("old"); len(entries) != 1 {
+		t.Fatalf("old entries = %+v", entries)
+	}
+	if err := ql.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ql.Query("old"); len(entries) != 0 {
+		t.Errorf("old entries after compaction = %+v", entries)
+	}
+	if entries, _ := ql.Query("q1"); len(entries) != 2 {
+		t.Errorf("entries after compaction = %+v", entries)
+	}
+}
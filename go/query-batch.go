@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Protocol ID for batch query streams
+const queryBatchProtocolID = "/p2p-rag/query-batch/0.0.1"
+
+// maxBatchItems caps how many vectors a single batch may carry
+const maxBatchItems = 64
+
+// How long a batch may take from reading the request to sending the
+// results; items not started by then are skipped
+const batchQueryTimeout = time.Minute
+
+// Number of batch items processed concurrently by the responder
+var batchWorkers = 4
+
+// BatchQueryItem is a single vector of a batch query
+type BatchQueryItem struct {
+	ExpertiseKey string `json:"expertise_key"`
+	MatchCount   int    `json:"match_count"`
+	Vector       Vector `json:"vector"`
+}
+
+// BatchQueryRequest carries several query vectors sent over one stream
+type BatchQueryRequest struct {
+	QueryId string           `json:"queryId"`
+	Model   string           `json:"model"`
+	Items   []BatchQueryItem `json:"items"`
+}
+
+// itemRequest returns the query a batch item stands for. Item results are
+// signed and verified against it, like results of single queries.
+func (r BatchQueryRequest) itemRequest(i int) QueryRequest {
+	item := r.Items[i]
+	return QueryRequest{
+		QueryId:      r.QueryId,
+		ExpertiseKey: item.ExpertiseKey,
+		Model:        r.Model,
+		MatchCount:   item.MatchCount,
+		Vector:       item.Vector,
+	}
+}
+
+// BatchQueryResponse holds one QueryResponse per item, in request order
+type BatchQueryResponse struct {
+	Success bool            `json:"success"`
+	Error   string          `json:"error,omitempty"`
+	Results []QueryResponse `json:"results,omitempty"`
+}
+
+// setupBatchQueryProtocol initializes the batch query protocol handler
+func setupBatchQueryProtocol(host host.Host) {
+	host.SetStreamHandler(protocol.ID(queryBatchProtocolID), handleBatchQueryStream)
+}
+
+// handleBatchQueryStream handles incoming batch query streams from other peers
+func handleBatchQueryStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(batchQueryTimeout))
+
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+
+	var request BatchQueryRequest
+	var results []QueryResponse
+	start := time.Now()
+	served := false
+	errorCode := ""
+	defer func() {
+		events.Publish(eventQueryServed, stream.Conn().RemotePeer().String(), map[string]interface{}{
+			"items":       len(request.Items),
+			"success":     served,
+			"duration_ms": time.Since(start).Milliseconds(),
+		})
+		auditBatchQuery(queryInbound, stream.Conn().RemotePeer().String(), request, results, start, errorCode, queryErrBackend)
+	}()
+	if err := json.NewDecoder(rw.Reader).Decode(&request); err != nil {
+		logger.Warn("❌ Error decoding batch query request:", err)
+		errorCode = queryErrBadRequest
+		sendBatchErrorResponse(rw, "Failed to decode request")
+		return
+	}
+
+	logger.Info("📥 Received batch query request from peer:", stream.Conn().RemotePeer(), " with ", len(request.Items), " items")
+
+	if err := validateBatchQuery(request); err != nil {
+		errorCode = queryErrInvalidQuery
+		sendBatchErrorResponse(rw, err.Error())
+		return
+	}
+
+	// Stop querying the backend once the requester gave up or time ran out
+	ctx, cancel := context.WithTimeout(context.Background(), batchQueryTimeout)
+	defer cancel()
+	cancelOnReset(stream, cancel)
+
+	results = processBatchQuery(ctx, request)
+	if ctx.Err() != nil {
+		logger.Warn("❌ Batch query from peer ", stream.Conn().RemotePeer(), " was cancelled:", ctx.Err())
+		errorCode = queryErrCancelled
+		return
+	}
+	response := BatchQueryResponse{
+		Success: true,
+		Results: results,
+	}
+
+	if err := json.NewEncoder(rw.Writer).Encode(response); err != nil {
+		logger.Warn("❌ Error encoding batch query response:", err)
+		errorCode = queryErrSend
+		return
+	}
+
+	if err := rw.Writer.Flush(); err != nil {
+		logger.Warn("❌ Error flushing batch response:", err)
+		errorCode = queryErrSend
+		return
+	}
+	served = true
+
+	logger.Info("📤 Sent batch query response to peer:", stream.Conn().RemotePeer())
+}
+
+// auditBatchQuery records a failed batch as a single audit entry with
+// errorCode. A batch that was answered gets one entry per item, items
+// without a successful result are recorded with itemErrorCode.
+func auditBatchQuery(direction string, peerId string, request BatchQueryRequest, results []QueryResponse, start time.Time, errorCode string, itemErrorCode string) {
+	entry := QueryAuditEntry{
+		Time:      start,
+		Direction: direction,
+		QueryId:   request.QueryId,
+		PeerId:    peerId,
+		LatencyMs: time.Since(start).Milliseconds(),
+		ErrorCode: errorCode,
+	}
+	if errorCode != "" {
+		queryAudit.Record(entry)
+		return
+	}
+
+	// Results only exist for batches that passed validation
+	entries := make([]QueryAuditEntry, 0, len(results))
+	for i, result := range results {
+		item := request.Items[i]
+		entry.ExpertiseKey = item.ExpertiseKey
+		entry.MatchCount = item.MatchCount
+		entry.ResultCount = 0
+		entry.ErrorCode = ""
+		if result.Success {
+			entry.ResultCount = countResultDocuments(result.Result)
+		} else {
+			entry.ErrorCode = itemErrorCode
+		}
+		entries = append(entries, entry)
+	}
+	queryAudit.Record(entries...)
+}
+
+// validateBatchQuery checks the batch size before any work is scheduled
+func validateBatchQuery(request BatchQueryRequest) error {
+	if len(request.Items) == 0 {
+		return fmt.Errorf("batch must contain at least one item")
+	}
+	if len(request.Items) > maxBatchItems {
+		return fmt.Errorf("batch must contain at most %d items", maxBatchItems)
+	}
+	return nil
+}
+
+// processBatchQuery forwards every item to the local API using a bounded
+// worker pool. Each item gets its own signed result or error. Items not
+// started when ctx is cancelled fail without reaching the backend.
+func processBatchQuery(ctx context.Context, request BatchQueryRequest) []QueryResponse {
+	results := make([]QueryResponse, len(request.Items))
+
+	workers := min(max(batchWorkers, 1), len(request.Items))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := ctx.Err(); err != nil {
+					results[i] = QueryResponse{Success: false, Error: fmt.Sprintf("Batch query cancelled: %s", err.Error())}
+					continue
+				}
+				itemRequest := request.itemRequest(i)
+				result, err := searchBackend.Query(ctx, itemRequest)
+				if err != nil {
+					logger.Warn("❌ Error processing batch item ", i, ":", err)
+					results[i] = QueryResponse{Success: false, Error: fmt.Sprintf("Failed to process query: %s", err.Error())}
+					continue
+				}
+				response, err := signLocalQueryResult(itemRequest, result)
+				if err != nil {
+					logger.Warn("❌ Error signing batch item ", i, ":", err)
+					results[i] = QueryResponse{Success: false, Error: "Failed to sign result"}
+					continue
+				}
+				results[i] = response
+			}
+		}()
+	}
+
+	for i := range request.Items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// sendBatchErrorResponse sends a batch-level error response back to the peer
+func sendBatchErrorResponse(rw *bufio.ReadWriter, errorMsg string) {
+	response := BatchQueryResponse{
+		Success: false,
+		Error:   errorMsg,
+	}
+
+	if err := json.NewEncoder(rw.Writer).Encode(response); err != nil {
+		logger.Warn("❌ Error encoding batch error response:", err)
+		return
+	}
+
+	if err := rw.Writer.Flush(); err != nil {
+		logger.Warn("❌ Error flushing batch error response:", err)
+	}
+}
+
+// queryRemotePeerBatch sends a batch query to a remote peer over a single
+// stream and verifies the signature of every item result. The batch counts
+// as one query towards the peer's reputation, with the latency of the whole
+// batch and the average number of documents per item.
+func queryRemotePeerBatch(ctx context.Context, host host.Host, peerIdStr string, request BatchQueryRequest) ([]QueryResponse, error) {
+	peerID, err := peer.Decode(peerIdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	var results []QueryResponse
+	start := time.Now()
+	errorCode := ""
+	outcome := QueryOutcome{}
+	defer func() {
+		outcome.Latency = time.Since(start)
+		if errorCode != "" && ctx.Err() != nil {
+			errorCode = queryErrCancelled
+		}
+		auditBatchQuery(queryOutbound, peerIdStr, request, results, start, errorCode, queryErrRemote)
+
+		// A cancelled batch says nothing about the peer
+		if errorCode == queryErrCancelled && !outcome.Malformed {
+			return
+		}
+		reputation.Record(peerID, outcome)
+	}()
+
+	if host.Network().Connectedness(peerID) != network.Connected {
+		errorCode = queryErrNotConnected
+		return nil, fmt.Errorf("not connected to peer %s", peerIdStr)
+	}
+
+	stream, err := host.NewStream(ctx, peerID, protocol.ID(queryBatchProtocolID))
+	if err != nil {
+		errorCode = queryErrStream
+		return nil, fmt.Errorf("failed to open stream to peer: %w", err)
+	}
+	defer stream.Close()
+
+	// Let the peer know when we give up, so it stops querying its backend
+	stop := context.AfterFunc(ctx, func() { stream.Reset() })
+	defer stop()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+
+	logger.Info("📤 Sending batch query request to peer:", peerID, " with ", len(request.Items), " items")
+
+	if err := json.NewEncoder(rw.Writer).Encode(request); err != nil {
+		errorCode = queryErrSend
+		return nil, fmt.Errorf("failed to encode batch query request: %w", err)
+	}
+
+	if err := rw.Writer.Flush(); err != nil {
+		errorCode = queryErrSend
+		return nil, fmt.Errorf("failed to send batch query request: %w", err)
+	}
+
+	var response BatchQueryResponse
+	if err := json.NewDecoder(rw.Reader).Decode(&response); err != nil {
+		if ctx.Err() == nil {
+			outcome.Malformed = true
+		}
+		errorCode = queryErrMalformedResponse
+		return nil, fmt.Errorf("failed to decode batch query response: %w", err)
+	}
+
+	if !response.Success {
+		errorCode = queryErrRemote
+		return nil, fmt.Errorf("batch query failed on peer: %s", response.Error)
+	}
+
+	if len(response.Results) != len(request.Items) {
+		outcome.Malformed = true
+		errorCode = queryErrMalformedResponse
+		return nil, fmt.Errorf("peer returned %d results for %d items", len(response.Results), len(request.Items))
+	}
+
+	// Check that every result was signed by the peer we queried
+	documents := 0
+	for i, result := range response.Results {
+		if !result.Success {
+			continue
+		}
+		if result.Signer != peerID.String() {
+			outcome.Malformed = true
+			errorCode = queryErrBadSignature
+			return nil, fmt.Errorf("result of item %d signed by %q instead of %s", i, result.Signer, peerID)
+		}
+		if err := verifyQueryResult(stream.Conn().RemotePublicKey(), request.itemRequest(i), result.Result, result.Signature); err != nil {
+			outcome.Malformed = true
+			errorCode = queryErrBadSignature
+			return nil, fmt.Errorf("result of item %d: %w", i, err)
+		}
+		documents += countResultDocuments(result.Result)
+	}
+
+	logger.Info("📥 Received batch query response from peer:", peerID)
+
+	outcome.Success = true
+	outcome.ResultCount = documents / len(request.Items)
+	results = response.Results
+	return results, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// itemBackend fails the items whose expertise key is "broken" and answers
+// the others with one document per match
+type itemBackend struct {
+	fakeSearchBackend
+}
+
+func (b *itemBackend) Query(ctx context.Context, request QueryRequest) (interface{}, error) {
+	b.fakeSearchBackend.Query(ctx, request)
+	if request.ExpertiseKey == "broken" {
+		return nil, errors.New("connection refused")
+	}
+	ids := make([]string, request.MatchCount)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s-%d", request.ExpertiseKey, i)
+	}
+	return memoryResult(ids...), nil
+}
+
+func TestProcessBatchQuery(t *testing.T) {
+	_, local := newTestHosts(t)
+	backend := &itemBackend{}
+	useSearchBackend(t, backend)
+
+	request := BatchQueryRequest{QueryId: "b1", Model: "m", Items: []BatchQueryItem{
+		{ExpertiseKey: "go", MatchCount: 1},
+		{ExpertiseKey: "broken", MatchCount: 1},
+		{ExpertiseKey: "rust", MatchCount: 3},
+	}}
+	results := processBatchQuery(context.Background(), request)
+
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if !results[0].Success || countResultDocuments(results[0].Result) != 1 {
+		t.Errorf("item 0: %+v", results[0])
+	}
+	if results[1].Success || results[1].Error == "" {
+		t.Errorf("item 1 should fail: %+v", results[1])
+	}
+	if !results[2].Success || countResultDocuments(results[2].Result) != 3 {
+		t.Errorf("item 2: %+v", results[2])
+	}
+	for _, i := range []int{0, 2} {
+		err := verifyQueryResult(local.Peerstore().PubKey(local.ID()), request.itemRequest(i), results[i].Result, results[i].Signature)
+		if results[i].Signer != local.ID().String() || err != nil {
+			t.Errorf("item %d is not signed by the node: %v", i, err)
+		}
+	}
+	for _, query := range backend.Queries() {
+		if query.QueryId != "b1" || query.Model != "m" {
+			t.Errorf("item query lost batch fields: %+v", query)
+		}
+	}
+}
+
+// blockingBackend counts how many queries run at the same time
+type blockingBackend struct {
+	fakeSearchBackend
+	running, peak int
+	mutex         sync.Mutex
+}
+
+func (b *blockingBackend) Query(ctx context.Context, request QueryRequest) (interface{}, error) {
+	b.mutex.Lock()
+	b.running++
+	b.peak = max(b.peak, b.running)
+	b.mutex.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	b.mutex.Lock()
+	b.running--
+	b.mutex.Unlock()
+	return memoryResult(), nil
+}
+
+func TestProcessBatchQueryStopsWhenCancelled(t *testing.T) {
+	newTestHosts(t)
+	backend := &fakeSearchBackend{result: memoryResult("a")}
+	useSearchBackend(t, backend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := processBatchQuery(ctx, BatchQueryRequest{Items: make([]BatchQueryItem, 4)})
+
+	for i, result := range results {
+		if result.Success {
+			t.Errorf("item %d succeeded after cancellation", i)
+		}
+	}
+	if got := len(backend.Queries()); got != 0 {
+		t.Errorf("backend received %d queries after cancellation", got)
+	}
+}
+
+func TestProcessBatchQueryBoundsWorkers(t *testing.T) {
+	newTestHosts(t)
+	backend := &blockingBackend{}
+	useSearchBackend(t, backend)
+	previous := batchWorkers
+	batchWorkers = 2
+	t.Cleanup(func() { batchWorkers = previous })
+
+	results := processBatchQuery(context.Background(), BatchQueryRequest{Items: make([]BatchQueryItem, 8)})
+
+	if len(results) != 8 {
+		t.Fatalf("got %d results, want 8", len(results))
+	}
+	if backend.peak > 2 {
+		t.Errorf("%d items ran concurrently, want at most 2", backend.peak)
+	}
+}
+
+func TestHandleBatchQueryStream(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupBatchQueryProtocol(local)
+	audit := useQueryAudit(t)
+	useSearchBackend(t, &itemBackend{})
+
+	request := BatchQueryRequest{QueryId: "b1", Items: []BatchQueryItem{
+		{ExpertiseKey: "go", MatchCount: 2},
+		{ExpertiseKey: "broken", MatchCount: 1},
+	}}
+	var response BatchQueryResponse
+	exchange(t, remote, local, queryBatchProtocolID, request, &response).Close()
+
+	if !response.Success || len(response.Results) != 2 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	entries := waitForAudit(t, audit, 2)
+	counts := map[string]QueryAuditEntry{}
+	for _, entry := range entries {
+		counts[entry.ExpertiseKey] = entry
+	}
+	if entry := counts["go"]; entry.ResultCount != 2 || entry.ErrorCode != "" {
+		t.Errorf("go item audited as %+v", entry)
+	}
+	if entry := counts["broken"]; entry.ErrorCode != queryErrBackend {
+		t.Errorf("broken item audited as %+v", entry)
+	}
+}
+
+func TestHandleBatchQueryStreamRejectsOversizedBatch(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupBatchQueryProtocol(local)
+	audit := useQueryAudit(t)
+	backend := &fakeSearchBackend{}
+	useSearchBackend(t, backend)
+
+	request := BatchQueryRequest{QueryId: "b1", Items: make([]BatchQueryItem, maxBatchItems+1)}
+	var response BatchQueryResponse
+	exchange(t, remote, local, queryBatchProtocolID, request, &response).Close()
+
+	if response.Success {
+		t.Fatalf("oversized batch was accepted")
+	}
+	if len(backend.Queries()) != 0 {
+		t.Errorf("backend was queried for a rejected batch")
+	}
+	entries := waitForAudit(t, audit, 1)
+	time.Sleep(50 * time.Millisecond)
+	if entries = auditEntries(t, audit); len(entries) != 1 || entries[0].ErrorCode != queryErrInvalidQuery {
+		t.Errorf("rejected batch audited as %+v, want a single invalid_query entry", entries)
+	}
+}
+
+func TestQueryRemotePeerBatch(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupBatchQueryProtocol(local)
+	useQueryAudit(t)
+	useReputation(t)
+	useSearchBackend(t, &itemBackend{})
+
+	request := BatchQueryRequest{QueryId: "b1", Items: []BatchQueryItem{
+		{ExpertiseKey: "go", MatchCount: 2},
+		{ExpertiseKey: "rust", MatchCount: 4},
+	}}
+	results, err := queryRemotePeerBatch(context.Background(), remote, local.ID().String(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].Success || !results[1].Success {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	scores := reputation.Scores()
+	if len(scores) != 1 || scores[0].Peer != local.ID().String() || scores[0].Successes < 0.99 || scores[0].AvgResults != 3 {
+		t.Errorf("batch recorded as %+v, want one success averaging 3 documents", scores)
+	}
+}
+
+func TestQueryRemotePeerBatchRejectsUnsignedResults(t *testing.T) {
+	remote, local := newTestHosts(t)
+	audit := useQueryAudit(t)
+	useReputation(t)
+
+	// A peer answering without signing its results
+	local.SetStreamHandler(queryBatchProtocolID, func(stream network.Stream) {
+		defer stream.Close()
+		var request BatchQueryRequest
+		json.NewDecoder(stream).Decode(&request)
+		json.NewEncoder(stream).Encode(BatchQueryResponse{Success: true, Results: []QueryResponse{{Success: true, Result: memoryResult("a")}}})
+	})
+
+	request := BatchQueryRequest{QueryId: "b1", Items: []BatchQueryItem{{ExpertiseKey: "go", MatchCount: 1}}}
+	if _, err := queryRemotePeerBatch(context.Background(), remote, local.ID().String(), request); err == nil {
+		t.Fatal("unsigned results were accepted")
+	}
+
+	if entries := auditEntries(t, audit); len(entries) != 1 || entries[0].ErrorCode != queryErrBadSignature {
+		t.Errorf("batch audited as %+v, want bad_signature", entries)
+	}
+	if scores := reputation.Scores(); len(scores) != 1 || scores[0].Malformed < 0.99 {
+		t.Errorf("batch recorded as %+v, want a malformed response", scores)
+	}
+}
+
+// waitingBackend blocks every query until its context is cancelled
+type waitingBackend struct {
+	fakeSearchBackend
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func (b *waitingBackend) Query(ctx context.Context, request QueryRequest) (interface{}, error) {
+	close(b.started)
+	select {
+	case <-ctx.Done():
+		close(b.cancelled)
+		return nil, ctx.Err()
+	case <-time.After(5 * time.Second):
+		return memoryResult(), nil
+	}
+}
+
+func TestHandleBatchQueryStreamCancelsWhenRequesterLeaves(t *testing.T) {
+	remote, local := newTestHosts(t)
+	setupBatchQueryProtocol(local)
+	audit := useQueryAudit(t)
+	useReputation(t)
+	backend := &waitingBackend{started: make(chan struct{}), cancelled: make(chan struct{})}
+	useSearchBackend(t, backend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := queryRemotePeerBatch(ctx, remote, local.ID().String(), BatchQueryRequest{QueryId: "b1", Items: make([]BatchQueryItem, 1)})
+		done <- err
+	}()
+
+	<-backend.started
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("cancelled batch succeeded")
+	}
+	select {
+	case <-backend.cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend query kept running after the requester gave up")
+	}
+
+	// Both sides audit the batch as cancelled
+	entries := waitForAudit(t, audit, 2)
+	for _, entry := range entries {
+		if entry.ErrorCode != queryErrCancelled {
+			t.Errorf("batch audited as %+v, want cancelled", entry)
+		}
+	}
+}
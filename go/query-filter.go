@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// QueryFilter is a metadata filter expression. Each key names a metadata
+// field and maps either to a literal (plain equality, matching the JSONB
+// containment used by match_site_pages) or to an object of operators, e.g.
+// {"source": "cf_docs", "date": {"$gte": "2024-01-01"}}. An object is only
+// read as operators when all its keys start with "$", so nested literals
+// like {"meta": {"lang": "en"}} still match by containment.
+type QueryFilter map[string]interface{}
+
+// knownFilterOperators lists every operator a filter may use
+var knownFilterOperators = []string{"$eq", "$ne", "$in", "$nin", "$gt", "$gte", "$lt", "$lte"}
+
+// QueryCapabilities describes which optional query features this node supports
+type QueryCapabilities struct {
+	FilterOperators []string `json:"filter_operators"`
+	TextSearch      bool     `json:"text_search"`
+}
+
+// localQueryCapabilities returns the capabilities this node advertises
+func localQueryCapabilities() QueryCapabilities {
+	return searchBackend.Capabilities()
+}
+
+// parseFilterOperators parses a comma separated list of filter operators.
+// Plain equality is always supported since it maps onto JSONB containment.
+func parseFilterOperators(value string) ([]string, error) {
+	operators := []string{"$eq"}
+	for _, op := range strings.Split(value, ",") {
+		op = strings.TrimSpace(op)
+		if op == "" || slices.Contains(operators, op) {
+			continue
+		}
+		if !slices.Contains(knownFilterOperators, op) {
+			return nil, fmt.Errorf("unknown filter operator %q", op)
+		}
+		operators = append(operators, op)
+	}
+	return operators, nil
+}
+
+// filterOperators returns the operators of a filter condition, or false if
+// the condition is a literal
+func filterOperators(condition interface{}) (map[string]interface{}, bool) {
+	ops, ok := condition.(map[string]interface{})
+	if !ok || len(ops) == 0 {
+		return nil, false
+	}
+	for op := range ops {
+		if !strings.HasPrefix(op, "$") {
+			return nil, false
+		}
+	}
+	return ops, true
+}
+
+// Operators returns the sorted set of operators used by the filter
+func (f QueryFilter) Operators() []string {
+	seen := make(map[string]bool)
+	for _, value := range f {
+		ops, ok := filterOperators(value)
+		if !ok {
+			seen["$eq"] = true
+			continue
+		}
+		for op := range ops {
+			seen[op] = true
+		}
+	}
+
+	operators := make([]string, 0, len(seen))
+	for op := range seen {
+		operators = append(operators, op)
+	}
+	sort.Strings(operators)
+	return operators
+}
+
+// Validate checks that the filter only uses the given operators
+func (f QueryFilter) Validate(supported []string) error {
+	for field, value := range f {
+		if field == "" {
+			return fmt.Errorf("filter field names must not be empty")
+		}
+		ops, ok := filterOperators(value)
+		if !ok {
+			continue
+		}
+		for op, operand := range ops {
+			if !slices.Contains(supported, op) {
+				return fmt.Errorf("filter operator %s is not supported", op)
+			}
+			if _, isList := operand.([]interface{}); (op == "$in" || op == "$nin") && !isList {
+				return fmt.Errorf("filter operator %s on %q requires a list", op, field)
+			}
+		}
+	}
+	return nil
+}
+
+// validateQueryOptions checks the optional filter and text of a query
+// against what this node supports
+func validateQueryOptions(filter QueryFilter, queryText string) error {
+	capabilities := localQueryCapabilities()
+	if err := filter.Validate(capabilities.FilterOperators); err != nil {
+		return err
+	}
+	if queryText != "" && !capabilities.TextSearch {
+		return fmt.Errorf("text search is not supported by this node")
+	}
+	return nil
+}
+
+// Matches evaluates the filter against a document's metadata
+func (f QueryFilter) Matches(metadata map[string]interface{}) bool {
+	for field, condition := range f {
+		value, exists := metadata[field]
+		ops, ok := filterOperators(condition)
+		if !ok {
+			if !exists || !filterValueContains(value, condition) {
+				return false
+			}
+			continue
+		}
+		for op, operand := range ops {
+			if !matchFilterOperator(op, value, exists, operand) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchFilterOperator(op string, value interface{}, exists bool, operand interface{}) bool {
+	switch op {
+	case "$eq":
+		return exists && filterValuesEqual(value, operand)
+	case "$ne":
+		return !exists || !filterValuesEqual(value, operand)
+	case "$in", "$nin":
+		found := false
+		list, _ := operand.([]interface{})
+		for _, candidate := range list {
+			if exists && filterValuesEqual(value, candidate) {
+				found = true
+				break
+			}
+		}
+		return found == (op == "$in")
+	case "$gt", "$gte", "$lt", "$lte":
+		if !exists {
+			return false
+		}
+		cmp, ok := compareFilterValues(value, operand)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "$gt":
+			return cmp > 0
+		case "$gte":
+			return cmp >= 0
+		case "$lt":
+			return cmp < 0
+		default:
+			return cmp <= 0
+		}
+	}
+	return false
+}
+
+// filterValueContains tells whether a literal matches a value the way JSONB
+// containment does: objects match if they hold every key of the literal
+func filterValueContains(value, literal interface{}) bool {
+	object, ok := literal.(map[string]interface{})
+	if !ok {
+		return filterValuesEqual(value, literal)
+	}
+	valueObject, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for key, expected := range object {
+		actual, exists := valueObject[key]
+		if !exists || !filterValueContains(actual, expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterValuesEqual compares decoded JSON values, treating all numbers alike
+func filterValuesEqual(a, b interface{}) bool {
+	if cmp, ok := compareFilterValues(a, b); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareFilterValues orders two numbers or two strings. Strings compare
+// lexicographically, which also orders ISO 8601 dates.
+func compareFilterValues(a, b interface{}) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+	x, ok := a.(string)
+	if !ok {
+		return 0, false
+	}
+	y, ok := b.(string)
+	if !ok {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// parseFilter decodes a filter the way it arrives in a query
+func parseFilter(t *testing.T, data string) QueryFilter {
+	var filter QueryFilter
+	if err := json.Unmarshal([]byte(data), &filter); err != nil {
+		t.Fatal(err)
+	}
+	return filter
+}
+
+func TestQueryFilterValidate(t *testing.T) {
+	tests := []struct {
+		filter string
+		valid  bool
+	}{
+		{filter: `{"source": "cf_docs"}`, valid: true},
+		{filter: `{"meta": {"lang": "en"}}`, valid: true},
+		{filter: `{"meta": {"$eq": {"lang": "en"}}}`, valid: true},
+		{filter: `{"meta": {"lang": "en", "$in": ["x"]}}`, valid: true},
+		{filter: `{"language": {"$in": ["en", "de"]}}`, valid: false},
+		{filter: `{"language": {"$regex": "e"}}`, valid: false},
+		{filter: `{"": "x"}`, valid: false},
+	}
+	for _, test := range tests {
+		err := parseFilter(t, test.filter).Validate([]string{"$eq"})
+		if (err == nil) != test.valid {
+			t.Errorf("Validate(%s) = %v, want valid %v", test.filter, err, test.valid)
+		}
+	}
+}
+
+func TestQueryFilterMatches(t *testing.T) {
+	metadata := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{"source": "cf_docs", "year": 2024, "meta": {"lang": "en", "tags": ["go"]}}`), &metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter  string
+		matches bool
+	}{
+		{filter: `{"source": "cf_docs"}`, matches: true},
+		{filter: `{"source": "other"}`, matches: false},
+		{filter: `{"meta": {"lang": "en"}}`, matches: true},
+		{filter: `{"meta": {"lang": "de"}}`, matches: false},
+		{filter: `{"meta": {"missing": "en"}}`, matches: false},
+		{filter: `{"source": {"lang": "en"}}`, matches: false},
+		{filter: `{"year": {"$gte": 2024, "$lt": 2025}}`, matches: true},
+		{filter: `{"source": {"$in": ["cf_docs", "blog"]}}`, matches: true},
+		{filter: `{"source": {"$nin": ["cf_docs"]}}`, matches: false},
+	}
+	for _, test := range tests {
+		if got := parseFilter(t, test.filter).Matches(metadata); got != test.matches {
+			t.Errorf("Matches(%s) = %v, want %v", test.filter, got, test.matches)
+		}
+	}
+}
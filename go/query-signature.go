@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// Domain separation prefix for signed query results
+const resultSignatureDomain = "p2p-rag/query-result/v1"
+
+// Response headers carrying the provenance of a /query result
+const (
+	signerHeader     = "X-P2P-Rag-Signer"
+	signatureHeader  = "X-P2P-Rag-Signature"
+	resultHashHeader = "X-P2P-Rag-Result-Hash"
+)
+
+// vectorHash hashes a query vector as little-endian float64 values
+func vectorHash(vector Vector) []byte {
+	buf := make([]byte, 8*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint64(buf[i*8:], math.Float64bits(v))
+	}
+	sum := sha256.Sum256(buf)
+	return sum[:]
+}
+
+// queryResultDigest computes the canonical hash that is signed for a query
+// result. The result is hashed in its JSON encoding, which is deterministic
+// for decoded JSON values since object keys are sorted.
+func queryResultDigest(queryId string, vector Vector, result interface{}) ([]byte, error) {
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+	resultSum := sha256.Sum256(resultJson)
+
+	canonical := resultSignatureDomain + "\n" +
+		queryId + "\n" +
+		hex.EncodeToString(vectorHash(vector)) + "\n" +
+		hex.EncodeToString(resultSum[:])
+
+	digest := sha256.Sum256([]byte(canonical))
+	return digest[:], nil
+}
+
+// signQueryResult signs a query result with the node's private key
+func signQueryResult(privateKey p2pcrypto.PrivKey, request QueryRequest, result interface{}) ([]byte, error) {
+	digest, err := queryResultDigest(request.QueryId, request.Vector, result)
+	if err != nil {
+		return nil, err
+	}
+	return privateKey.Sign(digest)
+}
+
+// verifyQueryResult checks a query result signature against the signer's public key
+func verifyQueryResult(publicKey p2pcrypto.PubKey, request QueryRequest, result interface{}, signature []byte) error {
+	if len(signature) == 0 {
+		return fmt.Errorf("result is not signed")
+	}
+	digest, err := queryResultDigest(request.QueryId, request.Vector, result)
+	if err != nil {
+		return err
+	}
+	ok, err := publicKey.Verify(digest, signature)
+	if err != nil {
+		return fmt.Errorf("failed to verify result signature: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("invalid result signature")
+	}
+	return nil
+}
+
+// signLocalQueryResult signs a result produced by this node
+func signLocalQueryResult(request QueryRequest, result interface{}) (QueryResponse, error) {
+	response := QueryResponse{Success: true, Result: result}
+	if globalHost == nil {
+		return response, fmt.Errorf("P2P host not initialized yet")
+	}
+
+	signature, err := signQueryResult(globalHost.Peerstore().PrivKey(globalHost.ID()), request, result)
+	if err != nil {
+		return response, fmt.Errorf("failed to sign result: %w", err)
+	}
+	response.Signature = signature
+	response.Signer = globalHost.ID().String()
+	return response, nil
+}
+
+// provenanceHeaders returns the headers describing who produced a result
+func provenanceHeaders(request QueryRequest, response QueryResponse) map[string]string {
+	headers := map[string]string{
+		signerHeader:    response.Signer,
+		signatureHeader: base64.StdEncoding.EncodeToString(response.Signature),
+	}
+	if digest, err := queryResultDigest(request.QueryId, request.Vector, response.Result); err == nil {
+		headers[resultHashHeader] = hex.EncodeToString(digest)
+	}
+	return headers
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Outcomes lose half their weight after this long
+const reputationHalfLife = 30 * time.Minute
+
+// How often dirty reputation data is written to disk
+const reputationSaveInterval = 30 * time.Second
+
+// Weight of the newest sample in the latency and result count averages
+const reputationAverageWeight = 0.2
+
+// QueryOutcome is what a single query tells us about a peer
+type QueryOutcome struct {
+	Latency     time.Duration
+	Success     bool
+	Malformed   bool
+	ResultCount int
+}
+
+// PeerReputation holds the decayed query statistics of one peer
+type PeerReputation struct {
+	Successes    float64   `json:"successes"`
+	Failures     float64   `json:"failures"`
+	Malformed    float64   `json:"malformed"`
+	AvgLatencyMs float64   `json:"avg_latency_ms"`
+	AvgResults   float64   `json:"avg_results"`
+	LastUpdated  time.Time `json:"last_updated"`
+}
+
+// decay ages the counters to the given time
+func (r *PeerReputation) decay(now time.Time) {
+	if r.LastUpdated.IsZero() || !now.After(r.LastUpdated) {
+		return
+	}
+	factor := math.Pow(0.5, float64(now.Sub(r.LastUpdated))/float64(reputationHalfLife))
+	r.Successes *= factor
+	r.Failures *= factor
+	r.Malformed *= factor
+	r.LastUpdated = now
+}
+
+// Score rates a peer between 0 and 1. Unknown peers start at 0.5; reliable
+// peers move towards 1 and fast peers are preferred over slow ones.
+func (r PeerReputation) Score() float64 {
+	// Malformed responses count double since they suggest a broken or hostile peer
+	reliability := (r.Successes + 1) / (r.Successes + r.Failures + 2*r.Malformed + 2)
+	if r.Successes == 0 {
+		return reliability
+	}
+	speed := 1 / (1 + r.AvgLatencyMs/1000)
+	return reliability * (0.5 + 0.5*speed)
+}
+
+// ReputationStore records query outcomes per peer and persists them
+type ReputationStore struct {
+	peers map[peer.ID]*PeerReputation
+	path  string
+	dirty bool
+	mutex sync.Mutex
+}
+
+// NewReputationStore loads the reputation data stored at path, if any
+func NewReputationStore(path string) (*ReputationStore, error) {
+	rs := &ReputationStore{
+		peers: make(map[peer.ID]*PeerReputation),
+		path:  path,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &rs.peers); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Record adds the outcome of a query to the peer's reputation
+func (rs *ReputationStore) Record(p peer.ID, outcome QueryOutcome) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	now := time.Now()
+	r, ok := rs.peers[p]
+	if !ok {
+		r = &PeerReputation{LastUpdated: now}
+		rs.peers[p] = r
+	}
+	r.decay(now)
+
+	switch {
+	case outcome.Malformed:
+		r.Malformed++
+	case outcome.Success:
+		latencyMs := float64(outcome.Latency.Milliseconds())
+		if r.Successes == 0 {
+			r.AvgLatencyMs = latencyMs
+			r.AvgResults = float64(outcome.ResultCount)
+		} else {
+			r.AvgLatencyMs += reputationAverageWeight * (latencyMs - r.AvgLatencyMs)
+			r.AvgResults += reputationAverageWeight * (float64(outcome.ResultCount) - r.AvgResults)
+		}
+		r.Successes++
+	default:
+		r.Failures++
+	}
+	rs.dirty = true
+}
+
+// Score returns the current score of a peer
+func (rs *ReputationStore) Score(p peer.ID) float64 {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	r, ok := rs.peers[p]
+	if !ok {
+		return PeerReputation{}.Score()
+	}
+	r.decay(time.Now())
+	return r.Score()
+}
+
+// PeerScore is a peer's reputation as reported by the API
+type PeerScore struct {
+	Peer  string  `json:"peer"`
+	Score float64 `json:"score"`
+	PeerReputation
+}
+
+// Scores returns the reputation of every known peer, best first
+func (rs *ReputationStore) Scores() []PeerScore {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	now := time.Now()
+	scores := make([]PeerScore, 0, len(rs.peers))
+	for p, r := range rs.peers {
+		r.decay(now)
+		scores = append(scores, PeerScore{Peer: p.String(), Score: r.Score(), PeerReputation: *r})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+	return scores
+}
+
+// Save writes the reputation data to disk if it changed
+func (rs *ReputationStore) Save() error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if !rs.dirty {
+		return nil
+	}
+	data, err := json.Marshal(rs.peers)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(rs.path, data); err != nil {
+		return err
+	}
+	rs.dirty = false
+	return nil
+}
+
+// saveReputationPeriodically persists the reputation data in the background
+func saveReputationPeriodically(rs *ReputationStore) {
+	for {
+		time.Sleep(reputationSaveInterval)
+		if err := rs.Save(); err != nil {
+			logger.Warn("❌ Error saving peer reputation:", err)
+		}
+	}
+}
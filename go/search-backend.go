@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// SearchBackend executes queries against the node's local documents. The
+// protocol handlers only talk to this interface, so nodes can run on
+// different storage and tests can inject a fake backend.
+type SearchBackend interface {
+	// Query returns the documents matching the request
+	Query(ctx context.Context, request QueryRequest) (interface{}, error)
+	// Health returns an error if the backend cannot currently answer queries
+	Health(ctx context.Context) error
+	// Capabilities describes the optional query features the backend supports
+	Capabilities() QueryCapabilities
+}
+
+// The backend answering queries for this node
+var searchBackend SearchBackend
+
+// errInvalidBackendQuery marks a query the backend could not be asked,
+// which says nothing about the backend itself
+var errInvalidBackendQuery = errors.New("invalid search backend query")
+
+// backendStatusError is an error status answered by the search API
+type backendStatusError struct {
+	StatusCode int
+}
+
+func (e *backendStatusError) Error() string {
+	return fmt.Sprintf("search API returned error status: %d", e.StatusCode)
+}
+
+// isBackendFailure tells whether err means the backend is failing, as
+// opposed to rejecting a query, which a peer can cause at will
+func isBackendFailure(err error) bool {
+	var statusErr *backendStatusError
+	switch {
+	case err == nil, errors.Is(err, errInvalidBackendQuery):
+		return false
+	case errors.As(err, &statusErr):
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// The built-in index, set when the memory backend is selected
+var memoryIndex *MemoryIndex
+
+// newSearchBackend creates the backend selected by the -backend flag
+func newSearchBackend(config Config) (SearchBackend, error) {
+	switch config.Backend {
+	case "http":
+		return NewHTTPSearchBackend(clientApiUrl, QueryCapabilities{
+			FilterOperators: config.FilterOperators,
+			TextSearch:      config.TextSearch,
+		}), nil
+	case "memory":
+		index, err := NewMemoryIndex(vectorDimension, config.IndexMetric)
+		if err != nil {
+			return nil, err
+		}
+		snapshotPath := filepath.Join(config.DataDir, "index.snapshot")
+		if err := index.LoadSnapshot(snapshotPath); err != nil {
+			return nil, err
+		}
+		logger.Info("📚 Loaded memory index with ", index.Len(), " documents")
+		go snapshotIndexPeriodically(index, snapshotPath)
+
+		memoryIndex = index
+		return NewMemorySearchBackend(index), nil
+	default:
+		return nil, fmt.Errorf("unknown search backend %q", config.Backend)
+	}
+}
+
+// HTTPSearchBackend forwards queries to the client API
+type HTTPSearchBackend struct {
+	url          string
+	capabilities QueryCapabilities
+	client       *http.Client
+}
+
+// NewHTTPSearchBackend creates a backend posting queries to url + "/query"
+func NewHTTPSearchBackend(url string, capabilities QueryCapabilities) *HTTPSearchBackend {
+	return &HTTPSearchBackend{
+		url:          url,
+		capabilities: capabilities,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Query forwards the query to the client API
+func (b *HTTPSearchBackend) Query(ctx context.Context, request QueryRequest) (interface{}, error) {
+	// Construct the query payload
+	type embeddingPayload struct {
+		Key        string      `json:"expertise_key"`
+		Model      string      `json:"model"`
+		Vector     Vector      `json:"vector"`
+		MatchCount int         `json:"match_count"`
+		Filter     QueryFilter `json:"filter,omitempty"`
+		QueryText  string      `json:"query_text,omitempty"`
+	}
+
+	queryPayload := struct {
+		QueryId   string           `json:"queryId"`
+		Embedding embeddingPayload `json:"embedding"`
+	}{
+		QueryId: request.QueryId,
+		Embedding: embeddingPayload{
+			Key:        request.ExpertiseKey,
+			Model:      request.Model,
+			Vector:     request.Vector,
+			MatchCount: request.MatchCount,
+			Filter:     request.Filter,
+			QueryText:  request.QueryText,
+		},
+	}
+
+	// Convert the payload to JSON
+	jsonData, err := json.Marshal(queryPayload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to marshal query data: %w", errInvalidBackendQuery, err)
+	}
+
+	// Send the query to the local search API
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url+"/query", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhookRequest(req, jsonData)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send query to search API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check for HTTP errors
+	if resp.StatusCode >= 400 {
+		return nil, &backendStatusError{StatusCode: resp.StatusCode}
+	}
+
+	// Parse and return the JSON response
+	var result interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse query response: %w", err)
+	}
+
+	return result, nil
+}
+
+// Health checks that the client API answers on url + "/health". Any status
+// below 500 counts as healthy, since the client API may not have that route.
+func (b *HTTPSearchBackend) Health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create health request: %w", err)
+	}
+	signWebhookRequest(req, nil)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("search API is unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("search API returned error status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Capabilities returns the features configured for the client API
+func (b *HTTPSearchBackend) Capabilities() QueryCapabilities {
+	return b.capabilities
+}
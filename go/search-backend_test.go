@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// fakeSearchBackend answers every query with the same result or error and
+// remembers the queries it received
+type fakeSearchBackend struct {
+	result       interface{}
+	err          error
+	healthErr    error
+	capabilities QueryCapabilities
+	queries      []QueryRequest
+	mutex        sync.Mutex
+}
+
+func (f *fakeSearchBackend) Query(ctx context.Context, request QueryRequest) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.queries = append(f.queries, request)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.result, nil
+}
+
+func (f *fakeSearchBackend) Health(ctx context.Context) error {
+	return f.healthErr
+}
+
+func (f *fakeSearchBackend) Capabilities() QueryCapabilities {
+	return f.capabilities
+}
+
+func (f *fakeSearchBackend) Queries() []QueryRequest {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]QueryRequest(nil), f.queries...)
+}
+
+// useSearchBackend makes backend answer queries for the rest of the test
+func useSearchBackend(t *testing.T, backend SearchBackend) {
+	previous := searchBackend
+	searchBackend = backend
+	t.Cleanup(func() { searchBackend = previous })
+}
+
+// memoryResult builds a result in the shape the memory backend returns
+func memoryResult(ids ...string) interface{} {
+	documents := make([]map[string]interface{}, len(ids))
+	for i, id := range ids {
+		documents[i] = map[string]interface{}{"id": id}
+	}
+	return map[string]interface{}{"answer": map[string]interface{}{"documents": documents}}
+}
+
+func TestIsBackendFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("connection refused"), true},
+		{&backendStatusError{StatusCode: 400}, false},
+		{&backendStatusError{StatusCode: 422}, false},
+		{&backendStatusError{StatusCode: 503}, true},
+		{errInvalidBackendQuery, false},
+	}
+	for _, test := range tests {
+		if got := isBackendFailure(test.err); got != test.want {
+			t.Errorf("isBackendFailure(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
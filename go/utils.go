@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
 )
 
 func generateRandomString(length int) string {
@@ -40,3 +43,66 @@ func hasIntersection[T fmt.Stringer](arr1 []T, arr2 []T) bool {
 	}
 	return false
 }
+
+// writeFileAtomic replaces a file so readers never see partial content
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// appendFile appends data to the file at path, creating it if needed
+func appendFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// countResultDocuments returns how many documents a search API result holds.
+// Results decoded from JSON hold []interface{}, while local backends may
+// return typed slices and maps, so both are inspected through reflection.
+func countResultDocuments(result interface{}) int {
+	value := reflect.ValueOf(result)
+	for value.Kind() == reflect.Interface || value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return 0
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		return value.Len()
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return 0
+		}
+		if answer := value.MapIndex(reflect.ValueOf("answer")); answer.IsValid() {
+			return countResultDocuments(answer.Interface())
+		}
+		documents := value.MapIndex(reflect.ValueOf("documents"))
+		if documents.IsValid() {
+			documents = reflect.ValueOf(documents.Interface())
+			if documents.Kind() == reflect.Slice || documents.Kind() == reflect.Array {
+				return documents.Len()
+			}
+		}
+	}
+	return 0
+}
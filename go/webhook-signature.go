@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers authenticating requests sent to the client API
+const (
+	webhookTimestampHeader = "X-P2P-Rag-Timestamp"
+	webhookSignatureHeader = "X-P2P-Rag-Webhook-Signature"
+)
+
+// Secret shared with the client API, requests are unsigned when empty
+var webhookSecret []byte
+
+// webhookSignature computes the hex HMAC-SHA256 of "<timestamp>.<body>"
+func webhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signWebhookRequest adds the timestamp and signature headers to a request
+// whose body is body. Covering the timestamp lets the client API reject
+// replayed requests.
+func signWebhookRequest(req *http.Request, body []byte) {
+	if len(webhookSecret) == 0 {
+		return
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+webhookSignature(webhookSecret, timestamp, body))
+}